@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// NotInAlbum skips assets that are already in this album (-not-in-album), and
+// with AddToAlbum every asset is added to it once its description is saved,
+// so the album is a record of what has been processed that is visible in
+// Immich.
+var (
+	NotInAlbum   string
+	NotInAlbumID string // resolved from NotInAlbum at startup
+	AddToAlbum   bool
+)
+
+// notInAlbumAssets holds the assets in NotInAlbum with -source api, where the
+// search can't exclude an album.
+var notInAlbumAssets map[string]bool
+
+// albumAssetIDs returns the IDs of the assets in an album, from the API.
+func albumAssetIDs(ctx context.Context, albumID string) (map[string]bool, error) {
+	var album struct {
+		Assets []struct {
+			ID string `json:"id"`
+		} `json:"assets"`
+	}
+	if err := immichJSON(ctx, "GET", "/api/albums/"+albumID, nil, &album); err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool, len(album.Assets))
+	for _, a := range album.Assets {
+		ids[a.ID] = true
+	}
+	return ids, nil
+}
+
+// addToAlbum adds a described asset to NotInAlbum.
+func addToAlbum(ctx context.Context, assetID string) error {
+	var results []struct {
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+	}
+	if err := immichJSON(ctx, "PUT", "/api/albums/"+NotInAlbumID+"/assets", map[string]any{"ids": []string{assetID}}, &results); err != nil {
+		return err
+	}
+	// An asset that is in the album already is fine.
+	if len(results) > 0 && !results[0].Success && results[0].Error != "duplicate" {
+		return fmt.Errorf("%s", results[0].Error)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/binary"
+)
+
+// Animated images are described by their first frame. image.Decode already
+// returns the first frame of a GIF, but golang.org/x/image/webp rejects
+// animated WebP files, so their first frame is extracted into a still WebP.
+
+// frameCount returns the number of frames of an animated GIF or WebP, or 1 for
+// anything else (including data it can't parse).
+func frameCount(data []byte) int {
+	if n := gifFrames(data); n > 0 {
+		return n
+	}
+	n := 0
+	for _, c := range webpChunks(data) {
+		if c.id == "ANMF" {
+			n++
+		}
+	}
+	return max(n, 1)
+}
+
+// gifFrames counts the image descriptors of a GIF, or returns 0 if data isn't a GIF.
+func gifFrames(data []byte) int {
+	if len(data) < 13 || (string(data[:6]) != "GIF87a" && string(data[:6]) != "GIF89a") {
+		return 0
+	}
+	pos := 13
+	if data[10]&0x80 != 0 { // global color table
+		pos += 3 << (data[10]&7 + 1)
+	}
+	frames := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // extension: label, then sub-blocks
+			pos = skipSubBlocks(data, pos+2)
+		case 0x2C: // image descriptor, optional local color table, LZW code size, sub-blocks
+			if pos+10 > len(data) {
+				return max(frames, 1)
+			}
+			frames++
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&7 + 1)
+			}
+			pos = skipSubBlocks(data, pos+1)
+		default: // trailer or garbage
+			return max(frames, 1)
+		}
+	}
+	return max(frames, 1)
+}
+
+func skipSubBlocks(data []byte, pos int) int {
+	for pos < len(data) && data[pos] != 0 {
+		pos += int(data[pos]) + 1
+	}
+	return pos + 1
+}
+
+type webpChunk struct {
+	id      string
+	payload []byte
+}
+
+// webpChunks splits a WebP file into its top-level chunks, or returns nil if
+// data isn't a WebP file.
+func webpChunks(data []byte) []webpChunk {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil
+	}
+	return riffChunks(data[12:])
+}
+
+func riffChunks(data []byte) []webpChunk {
+	var chunks []webpChunk
+	for len(data) >= 8 {
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		if size > len(data)-8 {
+			break
+		}
+		chunks = append(chunks, webpChunk{string(data[:4]), data[8 : 8+size]})
+		data = data[8+size:]
+		if size%2 == 1 && len(data) > 0 {
+			data = data[1:] // chunks are padded to an even size
+		}
+	}
+	return chunks
+}
+
+// webpFirstFrame returns the first frame of an animated WebP as a still WebP
+// file, or nil if data isn't an animated WebP.
+func webpFirstFrame(data []byte) []byte {
+	for _, c := range webpChunks(data) {
+		// ANMF: X, Y, width-1, height-1, duration (3 bytes each), flags, frame data.
+		if c.id != "ANMF" || len(c.payload) < 16 {
+			continue
+		}
+		width := int(uint24(c.payload[6:9])) + 1
+		height := int(uint24(c.payload[9:12])) + 1
+		var body []byte
+		alpha := false
+		for _, fc := range riffChunks(c.payload[16:]) {
+			if fc.id == "ALPH" {
+				alpha = true
+			}
+			body = appendChunk(body, fc.id, fc.payload)
+		}
+		if alpha {
+			// Lossy frames with an alpha channel need the extended format header.
+			vp8x := make([]byte, 10)
+			vp8x[0] = 0x10
+			putUint24(vp8x[4:7], uint32(width-1))
+			putUint24(vp8x[7:10], uint32(height-1))
+			body = append(appendChunk(nil, "VP8X", vp8x), body...)
+		}
+		out := make([]byte, 12, 12+len(body))
+		copy(out, "RIFF")
+		binary.LittleEndian.PutUint32(out[4:8], uint32(4+len(body)))
+		copy(out[8:], "WEBP")
+		return append(out, body...)
+	}
+	return nil
+}
+
+func appendChunk(dst []byte, id string, payload []byte) []byte {
+	dst = append(dst, id...)
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(payload)))
+	dst = append(dst, payload...)
+	if len(payload)%2 == 1 {
+		dst = append(dst, 0)
+	}
+	return dst
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
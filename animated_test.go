@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"slices"
+	"testing"
+)
+
+func testGIF(t *testing.T, frames int) []byte {
+	t.Helper()
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{}
+	for i := range frames {
+		img := image.NewPaletted(image.Rect(0, 0, 8, 8), palette)
+		img.SetColorIndex(i%8, i%8, 1)
+		anim.Image = append(anim.Image, img)
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGIFFrames(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"still", testGIF(t, 1), 1},
+		{"animated", testGIF(t, 3), 3},
+		{"header only", testGIF(t, 3)[:13], 1},
+		{"not a gif", []byte("\x89PNG\r\n\x1a\n0000000000"), 0},
+		{"too short", []byte("GIF89a"), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := gifFrames(tt.data); got != tt.want {
+				t.Errorf("gifFrames() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGIFFramesTruncated(t *testing.T) {
+	data := testGIF(t, 3)
+	for n := range len(data) {
+		if got := gifFrames(data[:n]); got < 0 || got > 3 {
+			t.Errorf("gifFrames(first %d bytes) = %d, want 0 to 3", n, got)
+		}
+	}
+}
+
+// riff builds a RIFF chunk, padded to an even size.
+func riff(id string, payload []byte) []byte {
+	return appendChunk(nil, id, payload)
+}
+
+// testWebP builds an animated WebP whose frames hold the given image chunks,
+// each 3x2 pixels. The image data isn't valid, which the parser doesn't care about.
+func testWebP(frames ...[]byte) []byte {
+	body := riff("VP8X", []byte{0x02, 0, 0, 0, 2, 0, 0, 1, 0, 0})
+	body = append(body, riff("ANIM", make([]byte, 6))...)
+	for _, frame := range frames {
+		header := make([]byte, 16)
+		putUint24(header[6:9], 2)
+		putUint24(header[9:12], 1)
+		body = append(body, riff("ANMF", append(header, frame...))...)
+	}
+	out := binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(4+len(body)))
+	out = append(out, "WEBP"...)
+	return append(out, body...)
+}
+
+func TestWebPFirstFrame(t *testing.T) {
+	lossless := riff("VP8L", []byte{1, 2, 3, 4, 5})
+	lossy := riff("VP8 ", []byte{6, 7, 8, 9})
+	alpha := riff("ALPH", []byte{10, 11})
+
+	tests := []struct {
+		name   string
+		data   []byte
+		chunks []string // chunk IDs of the extracted still, nil if none
+		frames int
+	}{
+		{"lossless", testWebP(lossless, lossy), []string{"VP8L"}, 2},
+		{"lossy with alpha", testWebP(append(alpha, lossy...)), []string{"VP8X", "ALPH", "VP8 "}, 1},
+		{"still", append(append([]byte("RIFF"), 12, 0, 0, 0), append([]byte("WEBP"), lossless...)...), nil, 1},
+		{"not a webp", []byte("GIF89a"), nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := frameCount(tt.data); got != tt.frames {
+				t.Errorf("frameCount() = %d, want %d", got, tt.frames)
+			}
+			still := webpFirstFrame(tt.data)
+			if tt.chunks == nil {
+				if still != nil {
+					t.Errorf("webpFirstFrame() = %q, want nil", still)
+				}
+				return
+			}
+			var ids []string
+			for _, c := range webpChunks(still) {
+				ids = append(ids, c.id)
+			}
+			if !slices.Equal(ids, tt.chunks) {
+				t.Errorf("webpFirstFrame() has chunks %q, want %q", ids, tt.chunks)
+			}
+			if size := binary.LittleEndian.Uint32(still[4:8]); int(size) != len(still)-8 {
+				t.Errorf("RIFF size = %d, want %d", size, len(still)-8)
+			}
+		})
+	}
+}
+
+func TestWebPFirstFrameTruncated(t *testing.T) {
+	first := riff("VP8L", []byte{1, 2, 3, 4, 5})
+	data := testWebP(first, riff("VP8L", []byte{6, 7, 8}))
+	firstEnd := len(testWebP(first))
+	for n := range len(data) {
+		still := webpFirstFrame(data[:n])
+		if n < firstEnd && still != nil {
+			t.Errorf("webpFirstFrame(first %d bytes) = %q, want nil", n, still)
+		}
+		if n >= firstEnd && still == nil {
+			t.Errorf("webpFirstFrame(first %d bytes) = nil, want the first frame", n)
+		}
+	}
+}
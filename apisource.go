@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Source selects how assets are listed and descriptions written (-source):
+// "db" (the default) queries Immich's Postgres database directly, "api" uses
+// only the Immich REST API, for setups without database access. The API can't
+// search for empty descriptions, so api mode pages through all assets and
+// filters them itself, which is much slower on large libraries.
+var Source string
+
+// apiPageSize is the number of assets per search page, Immich's maximum.
+const apiPageSize = 1000
+
+// apiAsset is the part of an Immich asset response used here.
+type apiAsset struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExifInfo  *struct {
+		Description string `json:"description"`
+	} `json:"exifInfo"`
+}
+
+func (a apiAsset) description() string {
+	if a.ExifInfo == nil {
+		return ""
+	}
+	return a.ExifInfo.Description
+}
+
+// pending reports whether the asset needs a description, applying the
+// filters the search endpoint doesn't support (see pendingFilter).
+func (a apiAsset) pending() bool {
+	if a.Type != "IMAGE" && (a.Type != "VIDEO" || !IncludeVideos) {
+		return false
+	}
+	if notInAlbumAssets[a.ID] {
+		return false
+	}
+	return Overwrite || a.description() == ""
+}
+
+// searchAssets returns one page of assets matching the album, date and
+// favorite filters, and the number of the next page (0 after the last one).
+func searchAssets(ctx context.Context, page, size int) ([]apiAsset, int, error) {
+	body := map[string]any{
+		"page":     page,
+		"size":     size,
+		"withExif": true,
+		"order":    strings.ToLower(orderDirection()),
+	}
+	if !IncludeVideos {
+		body["type"] = "IMAGE"
+	}
+	if OnlyFavorites {
+		body["isFavorite"] = true
+	}
+	if AlbumID != "" {
+		body["albumIds"] = []string{AlbumID}
+	}
+	if !CreatedAfter.IsZero() {
+		body["createdAfter"] = CreatedAfter
+	}
+	if !DateFrom.IsZero() {
+		body["takenAfter"] = DateFrom
+	}
+	if !DateTo.IsZero() {
+		body["takenBefore"] = DateTo.AddDate(0, 0, 1)
+	}
+	var out struct {
+		Assets struct {
+			Items    []apiAsset `json:"items"`
+			NextPage *string    `json:"nextPage"`
+		} `json:"assets"`
+	}
+	if err := immichJSON(ctx, "POST", "/api/search/metadata", body, &out); err != nil {
+		return nil, 0, err
+	}
+	next := 0
+	if out.Assets.NextPage != nil {
+		n, err := strconv.Atoi(*out.Assets.NextPage)
+		if err != nil {
+			return nil, 0, fmt.Errorf("unexpected next page %q", *out.Assets.NextPage)
+		}
+		next = n
+	}
+	return out.Assets.Items, next, nil
+}
+
+// apiPager hands out the pending assets of a search batch by batch. Saving a
+// description doesn't change the search results, so unlike the database scan
+// it keeps its position from one batch to the next.
+type apiPager struct {
+	page    int        // next page to fetch, 0 once the last one was fetched
+	pending []assetRef // fetched but not handed out yet
+}
+
+func newAPIPager() *apiPager {
+	return &apiPager{page: 1}
+}
+
+// reset starts over from the first page, for the next watch cycle.
+func (p *apiPager) reset() {
+	p.page, p.pending = 1, nil
+}
+
+// next returns up to limit pending assets, skipping excluded IDs. It returns
+// none once the search is exhausted.
+func (p *apiPager) next(ctx context.Context, excluded []string, limit int) ([]assetRef, error) {
+	for len(p.pending) < limit && p.page != 0 {
+		items, next, err := searchAssets(ctx, p.page, apiPageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range items {
+			if a.pending() && !slices.Contains(excluded, a.ID) {
+				p.pending = append(p.pending, assetRef{ID: a.ID, Type: a.Type, CreatedAt: a.CreatedAt})
+			}
+		}
+		p.page = next
+	}
+	n := min(limit, len(p.pending))
+	batch := p.pending[:n:n]
+	p.pending = p.pending[n:]
+	return batch, nil
+}
+
+// apiCount pages through all assets matching the search filters and counts
+// those for which match returns true, by asset type.
+func apiCount(ctx context.Context, match func(apiAsset) bool) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	for page := 1; page != 0; {
+		items, next, err := searchAssets(ctx, page, apiPageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range items {
+			if match(a) {
+				counts[a.Type]++
+			}
+		}
+		page = next
+	}
+	return counts, nil
+}
+
+// apiFindAssets is findAssets for -source api.
+func apiFindAssets(ctx context.Context, ids []string) ([]assetRef, []string, error) {
+	for _, id := range ids {
+		if !uuidPattern.MatchString(id) {
+			return nil, nil, fmt.Errorf("invalid asset ID %q", id)
+		}
+	}
+	assets := make([]assetRef, 0, len(ids))
+	var missing []string
+	for _, id := range ids {
+		var a apiAsset
+		err := immichJSON(ctx, "GET", "/api/assets/"+strings.ToLower(id), nil, &a)
+		var ie *immichError
+		if errors.As(err, &ie) && (ie.Status == http.StatusNotFound || ie.Status == http.StatusBadRequest) {
+			// Immich answers 400 for assets that don't exist or aren't accessible.
+			missing = append(missing, id)
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("asset %s: %v", id, err)
+		}
+		assets = append(assets, assetRef{ID: id, Type: a.Type})
+	}
+	return assets, missing, nil
+}
+
+// apiResolveAlbum is resolveAlbum for -source api.
+func apiResolveAlbum(ctx context.Context, album string) (string, string, error) {
+	var albums []struct {
+		ID        string `json:"id"`
+		AlbumName string `json:"albumName"`
+	}
+	if err := immichJSON(ctx, "GET", "/api/albums", nil, &albums); err != nil {
+		return "", "", fmt.Errorf("list albums: %v", err)
+	}
+	candidates := make([][2]string, len(albums))
+	for i, a := range albums {
+		candidates[i] = [2]string{a.ID, a.AlbumName}
+	}
+	slices.SortFunc(candidates, func(a, b [2]string) int { return strings.Compare(a[1], b[1]) })
+	return matchAlbum(album, candidates)
+}
+
+// apiSaveDescriptions writes descriptions through the Immich API, one request
+// per asset, stopping at the first error.
+func apiSaveDescriptions(ctx context.Context, writes []descriptionWrite) error {
+	for _, w := range writes {
+		if err := immichJSON(ctx, "PUT", "/api/assets/"+w.AssetID, map[string]any{"description": w.Desc}, nil); err != nil {
+			return fmt.Errorf("update asset %s: %w", w.AssetID, err)
+		}
+	}
+	return nil
+}
+
+// checkImmichAPI verifies that the Immich API is reachable and the API key
+// may search assets.
+func checkImmichAPI(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if _, _, err := searchAssets(ctx, 1, 1); err != nil {
+		return fmt.Errorf("cannot search %s: %v (check -immich-url and that the API key has the asset.read permission)", ImmichBaseURL, err)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build avif
+
+package main
+
+// AVIF decoding runs libavif compiled to WebAssembly, which adds several MB to
+// the binary, so it is only built with -tags avif:
+//
+//	go build -tags avif
+import _ "github.com/gen2brain/avif" // registers the "avif" image format
+
+func init() {
+	avifSupported = true
+}
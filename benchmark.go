@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"image/jpeg"
+	"os"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// benchmarkPrefetchWorkers is the number of benchmark images downloaded and
+// converted at the same time.
+const benchmarkPrefetchWorkers = 4
+
+// benchmarkImage is a benchmark image ready to send to the models.
+type benchmarkImage struct {
+	ID  string
+	B64 string
+	Err error // download or conversion failure
+}
+
+// prefetchBenchmarkImages downloads and converts the images concurrently and
+// returns them in the order of ids.
+func prefetchBenchmarkImages(ctx context.Context, ids []string) []benchmarkImage {
+	images := make([]benchmarkImage, len(ids))
+	sem := make(chan struct{}, benchmarkPrefetchWorkers)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			images[i] = benchmarkImage{ID: id}
+			data, err := downloadThumbnail(ctx, id)
+			if err != nil {
+				images[i].Err = fmt.Errorf("downloading: %v", err)
+				return
+			}
+			data, err = ensureJPEG(data, &jpeg.Options{Quality: JPEGQuality}, MaxDimension)
+			if err != nil {
+				images[i].Err = fmt.Errorf("converting: %v", err)
+				return
+			}
+			images[i].B64 = base64.StdEncoding.EncodeToString(data)
+		})
+	}
+	wg.Wait()
+	return images
+}
+
+// benchmarkResult is a single model run on a single image.
+type benchmarkResult struct {
+	AssetID  string
+	Model    string
+	Duration time.Duration
+	DescLen  int
+	Err      error
+}
+
+// benchmarkResults collects the timings of a benchmark run.
+type benchmarkResults struct {
+	models  []string
+	results []benchmarkResult
+}
+
+func (b *benchmarkResults) add(r benchmarkResult) {
+	b.results = append(b.results, r)
+}
+
+// inferenceTime is the total time spent waiting for the models.
+func (b *benchmarkResults) inferenceTime() time.Duration {
+	var total time.Duration
+	for _, r := range b.results {
+		total += r.Duration
+	}
+	return total
+}
+
+// printTable prints one row per model. Times and lengths only count successful runs.
+func (b *benchmarkResults) printTable() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Model\tAvg\tMin\tMax\tSuccess\tAvg chars")
+	for _, model := range b.models {
+		var runs, ok, chars int
+		var total, lo, hi time.Duration
+		for _, r := range b.results {
+			if r.Model != model {
+				continue
+			}
+			runs++
+			if r.Err != nil {
+				continue
+			}
+			ok++
+			chars += r.DescLen
+			total += r.Duration
+			if ok == 1 || r.Duration < lo {
+				lo = r.Duration
+			}
+			if r.Duration > hi {
+				hi = r.Duration
+			}
+		}
+		if ok == 0 {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t0/%d\t-\n", model, runs)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%.2fs\t%.2fs\t%.2fs\t%d/%d\t%d\n", model,
+			(total / time.Duration(ok)).Seconds(), lo.Seconds(), hi.Seconds(), ok, runs, chars/ok)
+	}
+	w.Flush()
+}
+
+// writeCSV writes the raw per-image, per-model results to path.
+func (b *benchmarkResults) writeCSV(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"asset_id", "model", "duration_seconds", "success", "description_length", "error"})
+	for _, r := range b.results {
+		errText := ""
+		if r.Err != nil {
+			errText = r.Err.Error()
+		}
+		w.Write([]string{
+			r.AssetID,
+			r.Model,
+			strconv.FormatFloat(r.Duration.Seconds(), 'f', 3, 64),
+			strconv.FormatBool(r.Err == nil),
+			strconv.Itoa(r.DescLen),
+			errText,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
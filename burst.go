@@ -0,0 +1,87 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// DedupeBursts describes only one image of each burst and copies its
+// description to the other shots (-dedupe-bursts). Shots belong to the same
+// burst if they were taken within BurstWindow of each other and look alike.
+var (
+	DedupeBursts bool
+	BurstWindow  time.Duration
+)
+
+// burstMaxDistance is the largest perceptual hash distance (out of 64 bits)
+// at which two shots still count as the same burst.
+const burstMaxDistance = 10
+
+// burstIndexSize bounds the number of recent shots remembered. Assets are
+// processed in capture order, so a burst is never far back.
+const burstIndexSize = 500
+
+// burstIndex remembers the recently described shots so later shots of the same
+// burst can reuse their description.
+type burstIndex struct {
+	mu      sync.Mutex
+	entries []*burstEntry
+}
+
+// burstEntry is a shot whose description is being generated or has been. done
+// is closed once res is final; res stays nil if the description failed.
+type burstEntry struct {
+	assetID   string
+	createdAt time.Time
+	hash      uint64
+	done      chan struct{}
+	res       *assetResult
+}
+
+// claim looks for an earlier shot of the same burst as a shot taken at
+// createdAt with perceptual hash hash. If there is none, the shot becomes a
+// representative: it is added to the index and returned with own set, and the
+// caller must call finish once its description is done. Otherwise the caller
+// waits for the returned entry's description.
+func (b *burstIndex) claim(assetID string, createdAt time.Time, hash uint64) (e *burstEntry, own bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := len(b.entries) - 1; i >= 0; i-- {
+		c := b.entries[i]
+		if c.createdAt.Sub(createdAt).Abs() <= BurstWindow && hashDistance(c.hash, hash) <= burstMaxDistance {
+			return c, false
+		}
+	}
+	e = &burstEntry{assetID: assetID, createdAt: createdAt, hash: hash, done: make(chan struct{})}
+	b.add(e)
+	return e, true
+}
+
+// follow adds a shot that reused the description of rep, so the burst can
+// continue past BurstWindow from its first shot.
+func (b *burstIndex) follow(rep *burstEntry, createdAt time.Time, hash uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e := &burstEntry{assetID: rep.assetID, createdAt: createdAt, hash: hash, done: rep.done, res: rep.res}
+	b.add(e)
+}
+
+func (b *burstIndex) add(e *burstEntry) {
+	b.entries = append(b.entries, e)
+	if len(b.entries) > burstIndexSize {
+		b.entries = b.entries[len(b.entries)-burstIndexSize:]
+	}
+}
+
+// finish publishes the description of a representative. If it failed (res is
+// nil), the shot is dropped from the index so the next one takes its place.
+func (b *burstIndex) finish(e *burstEntry, res *assetResult) {
+	b.mu.Lock()
+	e.res = res
+	if res == nil {
+		b.entries = slices.DeleteFunc(b.entries, func(c *burstEntry) bool { return c == e })
+	}
+	b.mu.Unlock()
+	close(e.done)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// CacheFile is the append-only log of generated descriptions (-cache-file).
+// Descriptions are recorded before they are written to the database, so GPU
+// work survives a database outage or a crash; empty disables it.
+var CacheFile string
+
+// cacheEntry is one line of the cache file. A generated description is
+// recorded with Saved false; a later line with the same asset ID and Saved
+// true marks it as written to the database.
+type cacheEntry struct {
+	AssetID     string    `json:"assetId"`
+	Saved       bool      `json:"saved,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Keywords    []string  `json:"keywords,omitempty"`
+	Model       string    `json:"model,omitempty"`
+	Hash        string    `json:"hash,omitempty"`
+	PHash       *int64    `json:"phash,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// cacheCompactLines is the number of lines after which the cache file is
+// rewritten with only the unsaved descriptions, if it can't simply be emptied
+// because some of them are never saved.
+const cacheCompactLines = 1000
+
+// descriptionCache appends to the cache file. A nil cache (disabled) is a no-op.
+type descriptionCache struct {
+	mu      sync.Mutex
+	f       *os.File
+	unsaved []cacheEntry // in the file but not saved yet
+	lines   int          // written since the file was last emptied or compacted
+}
+
+// readCache returns the descriptions in the cache file at path that were
+// generated but never marked as saved, in file order. A missing file is empty.
+func readCache(path string) ([]cacheEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var order []string
+	unsaved := make(map[string]cacheEntry)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var e cacheEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			// A crash can leave a partial last line; everything before it is intact.
+			Logger.Warn("skipping unreadable cache line", "path", path, "error", err.Error())
+			continue
+		}
+		if e.Saved {
+			delete(unsaved, e.AssetID)
+			continue
+		}
+		if _, ok := unsaved[e.AssetID]; !ok {
+			order = append(order, e.AssetID)
+		}
+		unsaved[e.AssetID] = e
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	var entries []cacheEntry
+	for _, id := range order {
+		if e, ok := unsaved[id]; ok {
+			entries = append(entries, e)
+			// An ID saved and generated again is in order twice.
+			delete(unsaved, id)
+		}
+	}
+	return entries, nil
+}
+
+// openCache opens the cache file for this run. The descriptions left unsaved
+// by an earlier run are kept in it and returned, for replayCache.
+func openCache(path string) (*descriptionCache, []cacheEntry, error) {
+	entries, err := readCache(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	c := &descriptionCache{f: f}
+	for _, e := range entries {
+		if err := c.append(e); err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+	}
+	return c, entries, nil
+}
+
+// replayCache writes the descriptions left unsaved by an earlier run to the
+// database, one by one, and does the rest of the work for a saved description
+// (checkpoint, tags, album, embedding, sidecar). Descriptions of assets that
+// no longer exist are dropped; those that fail otherwise are kept for the
+// next start.
+func (p *processor) replayCache(entries []cacheEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	textf("Saving %d descriptions cached by an earlier run (%s)...\n", len(entries), CacheFile)
+	saved, dropped, kept := 0, 0, 0
+	for _, e := range entries {
+		res := assetResult{ID: e.AssetID, Desc: e.Description, Keywords: e.Keywords, Model: e.Model, Hash: e.Hash, PHash: e.PHash, Start: time.Now()}
+		err := saveDescription(p.ctx, p.conn, res.write())
+		if assetGone(err) {
+			Logger.Warn("cached description dropped", "path", CacheFile, "asset_id", e.AssetID, "error", err.Error())
+			dropped++
+			if err := p.cache.saved(e.AssetID); err != nil {
+				Logger.Warn("cache write failed", "path", CacheFile, "error", err.Error())
+			}
+			continue
+		}
+		if err != nil {
+			Logger.Warn("cached description not saved", "path", CacheFile, "asset_id", e.AssetID, "error", err.Error())
+			kept++
+			continue
+		}
+		saved++
+		p.afterSave(p.ctx, &res)
+	}
+	if dropped > 0 {
+		textf("   [WARN] Dropped %d cached descriptions of assets that no longer exist\n", dropped)
+	}
+	if kept > 0 {
+		textf("   [WARN] Failed to save %d cached descriptions, keeping them for the next run\n", kept)
+	}
+	Logger.Info("cache replayed", "path", CacheFile, "saved", saved, "dropped", dropped, "kept", kept)
+}
+
+// add records a generated description before it is saved.
+func (c *descriptionCache) add(res assetResult) error {
+	return c.append(cacheEntry{
+		AssetID:     res.ID,
+		Description: res.Desc,
+		Keywords:    res.Keywords,
+		Model:       res.Model,
+		Hash:        res.Hash,
+		PHash:       res.PHash,
+		Time:        time.Now().UTC(),
+	})
+}
+
+// saved marks the description of assetID as written to the database.
+func (c *descriptionCache) saved(assetID string) error {
+	return c.append(cacheEntry{AssetID: assetID, Saved: true, Time: time.Now().UTC()})
+}
+
+func (c *descriptionCache) append(e cacheEntry) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.unsaved = slices.DeleteFunc(c.unsaved, func(u cacheEntry) bool { return u.AssetID == e.AssetID })
+	if !e.Saved {
+		c.unsaved = append(c.unsaved, e)
+	}
+	if len(c.unsaved) == 0 {
+		// Everything is saved, so there is nothing left to keep.
+		c.lines = 0
+		return c.f.Truncate(0)
+	}
+	if c.lines >= cacheCompactLines {
+		return c.compact()
+	}
+	return c.write(e)
+}
+
+// compact rewrites the file with only the unsaved descriptions.
+func (c *descriptionCache) compact() error {
+	if err := c.f.Truncate(0); err != nil {
+		return err
+	}
+	c.lines = 0
+	for _, e := range c.unsaved {
+		if err := c.write(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *descriptionCache) write(e cacheEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := c.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	c.lines++
+	return nil
+}
+
+func (c *descriptionCache) Close() error {
+	if c == nil {
+		return nil
+	}
+	return c.f.Close()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReadCache(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines string
+		want  []string // descriptions of the unsaved entries, in order
+	}{
+		{"empty", "", nil},
+		{"unsaved", `{"assetId":"a","description":"one"}` + "\n" + `{"assetId":"b","description":"two"}` + "\n", []string{"one", "two"}},
+		{"saved", `{"assetId":"a","description":"one"}` + "\n" + `{"assetId":"a","saved":true}` + "\n", nil},
+		{"regenerated", `{"assetId":"a","description":"one"}` + "\n" + `{"assetId":"b","description":"two"}` + "\n" + `{"assetId":"a","description":"three"}` + "\n", []string{"three", "two"}},
+		{"saved then regenerated", `{"assetId":"a","description":"one"}` + "\n" + `{"assetId":"a","saved":true}` + "\n" + `{"assetId":"a","description":"two"}` + "\n", []string{"two"}},
+		{"partial last line", `{"assetId":"a","description":"one"}` + "\n" + `{"assetId":"b","desc`, []string{"one"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cache.jsonl")
+			if err := os.WriteFile(path, []byte(tt.lines), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			entries, err := readCache(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range entries {
+				got = append(got, e.Description)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("readCache() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCacheMissing(t *testing.T) {
+	entries, err := readCache(filepath.Join(t.TempDir(), "missing.jsonl"))
+	if err != nil || entries != nil {
+		t.Errorf("readCache(missing) = %v, %v, want nil, nil", entries, err)
+	}
+}
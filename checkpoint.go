@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Checkpoint is the resume state persisted between runs.
+type Checkpoint struct {
+	LastAssetID    string    `json:"lastAssetId"`
+	TotalProcessed int       `json:"totalProcessed"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+	// LastCreatedAt is the newest asset creation time seen by the last
+	// complete scan, the starting point for -since-last-run.
+	LastCreatedAt time.Time `json:"lastCreatedAt,omitzero"`
+}
+
+// checkpointStore keeps the checkpoint in memory and rewrites the file after
+// every successful save. A nil store (checkpointing disabled) is a no-op.
+type checkpointStore struct {
+	mu   sync.Mutex
+	path string
+	cp   Checkpoint
+}
+
+// loadCheckpoint reads the checkpoint at path. A missing file starts from zero.
+func loadCheckpoint(path string) (*checkpointStore, error) {
+	s := &checkpointStore{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.cp); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Processed returns the total number of assets saved across all runs.
+func (s *checkpointStore) Processed() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cp.TotalProcessed
+}
+
+// Record notes a successfully saved asset and persists the checkpoint.
+func (s *checkpointStore) Record(assetID string) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cp.LastAssetID = assetID
+	s.cp.TotalProcessed++
+	return s.save()
+}
+
+// LastCreatedAt returns the creation time recorded by RecordScan.
+func (s *checkpointStore) LastCreatedAt() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cp.LastCreatedAt
+}
+
+// RecordScan notes the newest asset creation time seen by a complete scan.
+func (s *checkpointStore) RecordScan(newest time.Time) error {
+	if s == nil || newest.IsZero() {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !newest.After(s.cp.LastCreatedAt) {
+		return nil
+	}
+	s.cp.LastCreatedAt = newest
+	return s.save()
+}
+
+// save persists the checkpoint; s.mu must be held.
+func (s *checkpointStore) save() error {
+	s.cp.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(s.cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write to a temp file and rename so a crash never leaves a truncated checkpoint.
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".checkpoint-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFile is the path of the YAML configuration file (-config).
+var ConfigFile string
+
+// configFile is the structure of the -config file: any command-line option by
+// its flag name, plus an optional list of instances.
+type configFile struct {
+	path string
+	// Options maps flag names (and the env-only settings in envOnlyOptions)
+	// to their values, in file order.
+	Options []configOption
+	// Instances lists several Immich servers to process in one run.
+	Instances []instanceConfig
+}
+
+type configOption struct {
+	Key, Value string
+	Line       int
+}
+
+// instanceConfig describes one Immich server. Empty fields fall back to the
+// regular flags and environment variables.
+type instanceConfig struct {
+	Name       string `yaml:"name"`
+	Host       string `yaml:"host"`
+	ImmichURL  string `yaml:"immich-url"`
+	APIKey     string `yaml:"api-key"`
+	DBHost     string `yaml:"db-host"`
+	DBPort     string `yaml:"db-port"`
+	DBUser     string `yaml:"db-user"`
+	DBPassword string `yaml:"db-password"`
+	DBName     string `yaml:"db-name"`
+	Model      string `yaml:"model"`
+
+	// Secret files, read when the config is loaded; they win over the
+	// inline values above.
+	APIKeyFile     string `yaml:"api-key-file"`
+	DBPasswordFile string `yaml:"db-password-file"`
+}
+
+// envOnlyOptions are settings without a flag that can still be set in the
+// config file, keyed by config name.
+var envOnlyOptions = map[string]string{
+	"db-host":        "DB_HOST",
+	"db-port":        "DB_PORT",
+	"db-user":        "DB_USER",
+	"db-password":    "DB_PASS",
+	"db-name":        "DB_NAME",
+	"openai-api-key": "OPENAI_API_KEY",
+}
+
+// flagEnv lists the flags whose environment variable isn't simply the flag
+// name in upper case with '-' replaced by '_'.
+var flagEnv = map[string]string{
+	"host":             "IMMICH_HOST",
+	"key":              "IMMICH_API_KEY",
+	"ollama":           "OLLAMA_HOST",
+	"model":            "OLLAMA_MODEL",
+	"openai-url":       "OPENAI_BASE_URL",
+	"interval":         "WATCH_INTERVAL",
+	"language":         "DESCRIPTION_LANGUAGE",
+	"from":             "DATE_FROM",
+	"to":               "DATE_TO",
+	"order":            "SORT_ORDER",
+	"serve":            "SERVE_ADDR",
+	"db-password-file": "DB_PASS_FILE",
+	"max-interval":     "MAX_WATCH_INTERVAL",
+	"config":           "CONFIG_FILE",
+}
+
+func envName(flagName string) string {
+	if env, ok := flagEnv[flagName]; ok {
+		return env
+	}
+	return strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// instanceName restricts names to what is safe in file names, since they are
+// used to derive per-instance checkpoint and report paths.
+var instanceName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// loadConfig reads and validates a config file. Unknown keys are an error, so
+// typos don't go unnoticed.
+func loadConfig(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	cfg := &configFile{path: path}
+	if len(doc.Content) == 0 {
+		return cfg, nil // empty file
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: expected a mapping of option names to values", path)
+	}
+
+	for i := 0; i < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch {
+		case key.Value == "instances":
+			if err := cfg.decodeInstances(value); err != nil {
+				return nil, err
+			}
+		case key.Value == "config":
+			return nil, fmt.Errorf("%s:%d: config files can't include other config files", path, key.Line)
+		case flag.Lookup(key.Value) != nil || envOnlyOptions[key.Value] != "":
+			v, err := scalarValue(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %s: %v", path, key.Line, key.Value, err)
+			}
+			cfg.Options = append(cfg.Options, configOption{Key: key.Value, Value: v, Line: key.Line})
+		default:
+			return nil, unknownKey(path, key, optionNames())
+		}
+	}
+	return cfg, nil
+}
+
+// scalarValue returns a YAML value as it would be written on the command line.
+// Lists are joined with commas, which every list-valued flag accepts.
+func scalarValue(n *yaml.Node) (string, error) {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		return n.Value, nil
+	case yaml.SequenceNode:
+		var items []string
+		for _, item := range n.Content {
+			if item.Kind != yaml.ScalarNode {
+				return "", fmt.Errorf("expected a list of values")
+			}
+			items = append(items, item.Value)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("expected a value or a list of values")
+}
+
+func (c *configFile) decodeInstances(n *yaml.Node) error {
+	if n.Kind != yaml.SequenceNode {
+		return fmt.Errorf("%s:%d: instances must be a list", c.path, n.Line)
+	}
+	known := yamlKeys(instanceConfig{})
+	for i, item := range n.Content {
+		if item.Kind != yaml.MappingNode {
+			return fmt.Errorf("%s:%d: instance %d must be a mapping", c.path, item.Line, i+1)
+		}
+		for j := 0; j < len(item.Content); j += 2 {
+			if key := item.Content[j]; !contains(known, key.Value) {
+				return unknownKey(c.path, key, known)
+			}
+		}
+		var inst instanceConfig
+		if err := item.Decode(&inst); err != nil {
+			return fmt.Errorf("%s: %v", c.path, err)
+		}
+		if !instanceName.MatchString(inst.Name) {
+			return fmt.Errorf("%s:%d: instance %d needs a name of letters, digits, '-' or '_'", c.path, item.Line, i+1)
+		}
+		for _, other := range c.Instances {
+			if other.Name == inst.Name {
+				return fmt.Errorf("%s:%d: duplicate instance name %q", c.path, item.Line, inst.Name)
+			}
+		}
+		for _, s := range []struct {
+			key   string
+			path  string
+			value *string
+		}{
+			{"api-key-file", inst.APIKeyFile, &inst.APIKey},
+			{"db-password-file", inst.DBPasswordFile, &inst.DBPassword},
+		} {
+			if s.path == "" {
+				continue
+			}
+			secret, err := readSecret(s.path)
+			if err != nil {
+				return fmt.Errorf("%s:%d: instance %s: %s: %v", c.path, item.Line, inst.Name, s.key, err)
+			}
+			*s.value = secret
+		}
+		c.Instances = append(c.Instances, inst)
+	}
+	return nil
+}
+
+// apply sets every option that was given neither on the command line nor in
+// the environment, so the precedence is flags > env > config file > defaults.
+func (c *configFile) apply() error {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, opt := range c.Options {
+		if _, ok := envOnlyOptions[opt.Key]; ok || set[opt.Key] {
+			continue
+		}
+		if _, ok := os.LookupEnv(envName(opt.Key)); ok {
+			continue
+		}
+		if err := flag.Set(opt.Key, opt.Value); err != nil {
+			return fmt.Errorf("%s:%d: invalid value %q for %s: %v", c.path, opt.Line, opt.Value, opt.Key, err)
+		}
+	}
+	return nil
+}
+
+// env returns the config value of an env-only setting, or fallback if it is
+// set in the environment or not in the file.
+func (c *configFile) env(key, fallback string) string {
+	if _, ok := os.LookupEnv(key); ok {
+		return fallback
+	}
+	for _, opt := range c.Options {
+		if envOnlyOptions[opt.Key] == key {
+			return opt.Value
+		}
+	}
+	return fallback
+}
+
+// optionNames returns every key accepted at the top level of a config file.
+func optionNames() []string {
+	names := []string{"instances"}
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name != "config" {
+			names = append(names, f.Name)
+		}
+	})
+	for name := range envOnlyOptions {
+		names = append(names, name)
+	}
+	return names
+}
+
+// yamlKeys returns the yaml field names of struct v.
+func yamlKeys(v any) []string {
+	t := reflect.TypeOf(v)
+	keys := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		keys = append(keys, strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0])
+	}
+	return keys
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// unknownKey reports a key that isn't a known option, suggesting the closest
+// known one when it looks like a typo.
+func unknownKey(path string, key *yaml.Node, known []string) error {
+	best, bestDist := "", 3 // only suggest names within two edits
+	for _, name := range known {
+		if d := editDistance(key.Value, name); d < bestDist {
+			best, bestDist = name, d
+		}
+	}
+	if best != "" {
+		return fmt.Errorf("%s:%d: unknown option %q (did you mean %q?)", path, key.Line, key.Value, best)
+	}
+	return fmt.Errorf("%s:%d: unknown option %q (run with -h for the list of options)", path, key.Line, key.Value)
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
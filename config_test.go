@@ -0,0 +1,98 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"testing"
+)
+
+// envDefault returns the environment variable read by a flag default such as
+// getEnv("NAME", ...) or envInt("NAME", ...), or "" if e isn't one.
+func envDefault(e ast.Expr) string {
+	call, ok := e.(*ast.CallExpr)
+	if !ok || len(call.Args) == 0 {
+		return ""
+	}
+	fn, ok := call.Fun.(*ast.Ident)
+	if !ok {
+		return ""
+	}
+	switch fn.Name {
+	case "getEnv", "envInt", "envDuration", "envFloat":
+	default:
+		return ""
+	}
+	lit, ok := call.Args[0].(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return ""
+	}
+	name, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// TestFlagEnvNames checks that envName, which the config file relies on for
+// the flags > env > config precedence, names the environment variable each
+// flag's default is actually read from.
+func TestFlagEnvNames(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "main.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Defaults read into a variable first, e.g. envImmichKey := getEnv(...).
+	vars := make(map[string]string)
+	ast.Inspect(file, func(n ast.Node) bool {
+		if as, ok := n.(*ast.AssignStmt); ok && len(as.Lhs) == 1 && len(as.Rhs) == 1 {
+			if id, ok := as.Lhs[0].(*ast.Ident); ok {
+				if env := envDefault(as.Rhs[0]); env != "" {
+					vars[id.Name] = env
+				}
+			}
+		}
+		return true
+	})
+
+	tests := make(map[string]string) // flag name -> environment variable
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) < 3 {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if pkg, ok := sel.X.(*ast.Ident); !ok || pkg.Name != "flag" {
+			return true
+		}
+		lit, ok := call.Args[1].(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			return true
+		}
+		name, _ := strconv.Unquote(lit.Value)
+		env := envDefault(call.Args[2])
+		if id, ok := call.Args[2].(*ast.Ident); ok {
+			env = vars[id.Name]
+		}
+		if env != "" {
+			tests[name] = env
+		}
+		return true
+	})
+	if len(tests) < 50 {
+		t.Fatalf("found only %d flags with an environment default, is the parsing still right?", len(tests))
+	}
+
+	for flagName, env := range tests {
+		t.Run(flagName, func(t *testing.T) {
+			if got := envName(flagName); got != env {
+				t.Errorf("envName(%q) = %q, but the flag reads %s", flagName, got, env)
+			}
+		})
+	}
+}
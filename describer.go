@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// NumPredict is the maximum number of tokens generated per description (0 = backend default).
+var NumPredict int
+
+// Sampling options; nil leaves the backend's default in place.
+var (
+	Temperature *float64
+	TopP        *float64
+	Seed        *int
+)
+
+// ModelOptions holds arbitrary Ollama options from -model-options-file, e.g.
+// num_ctx or repeat_penalty. They override the options set by flags.
+var ModelOptions map[string]interface{}
+
+// loadModelOptions reads a -model-options-file, which must hold a JSON object.
+func loadModelOptions(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// Keep numbers as written, so large seeds don't lose precision.
+	dec.UseNumber()
+	var opts map[string]interface{}
+	if err := dec.Decode(&opts); err != nil || opts == nil {
+		return nil, fmt.Errorf("%s must contain a JSON object of Ollama options, e.g. {\"num_ctx\": 4096}", path)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("%s contains more than one JSON value", path)
+	}
+	return opts, nil
+}
+
+// ollamaOptions returns the "options" sent with every Ollama request.
+func ollamaOptions() map[string]interface{} {
+	opts := map[string]interface{}{}
+	if NumPredict > 0 {
+		opts["num_predict"] = NumPredict
+	}
+	if Temperature != nil {
+		opts["temperature"] = *Temperature
+	}
+	if TopP != nil {
+		opts["top_p"] = *TopP
+	}
+	if Seed != nil {
+		opts["seed"] = *Seed
+	}
+	for k, v := range ModelOptions {
+		opts[k] = v
+	}
+	return opts
+}
+
+// Describer generates a description for base64-encoded JPEGs using the given prompt and model.
+// Usually there is one image; with -describe-stacks the shots of a stack are sent together.
+// Implementations wrap transient failures (network errors, 5xx) in retryableError.
+type Describer interface {
+	Describe(ctx context.Context, prompt string, images []string, modelName string) (string, error)
+}
+
+// newDescriber builds the Describer selected by -backend.
+func newDescriber() Describer {
+	client := &http.Client{Timeout: 0, Transport: httpTransport}
+	if Backend == "openai" {
+		return &OpenAIDescriber{Client: client, BaseURL: strings.TrimRight(OpenAIBaseURL, "/"), APIKey: OpenAIAPIKey}
+	}
+	d := &OllamaDescriber{Client: client, Host: OllamaHost, Stream: Stream, KeepAlive: keepAliveValue(KeepAlive)}
+	// Echo tokens live only when a single worker owns the terminal.
+	if Stream && VerboseMode && InferenceWorkers == 1 {
+		d.OnToken = func(s string) { textf("%s", s) }
+	}
+	return d
+}
+
+// Ollama /api/chat payloads
+type ChatRequest struct {
+	Model     string                 `json:"model"`
+	Messages  []Message              `json:"messages"`
+	Stream    bool                   `json:"stream"`
+	Options   map[string]interface{} `json:"options"`
+	KeepAlive any                    `json:"keep_alive,omitempty"`
+	Format    any                    `json:"format,omitempty"` // "json" or a JSON schema with -structured
+}
+
+type Message struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"`
+}
+
+type ChatResponse struct {
+	Message struct {
+		Content string `json:"content"`
+	}
+	Done  bool   `json:"done"`
+	Error string `json:"error,omitempty"`
+}
+
+// OllamaDescriber talks to Ollama's native /api/chat endpoint. With Stream set
+// the response is read as NDJSON chunks, and OnToken (if set) sees each piece
+// of content as it arrives, followed by a final "\n". KeepAlive, if non-nil,
+// is sent as keep_alive to control how long Ollama keeps the model loaded.
+type OllamaDescriber struct {
+	Client    *http.Client
+	Host      string
+	Stream    bool
+	OnToken   func(string)
+	KeepAlive any
+}
+
+// keepAliveValue converts -keep-alive into the form Ollama expects: plain
+// numbers are seconds (negative = forever), anything else a duration string.
+// Empty leaves Ollama's default in place.
+func keepAliveValue(s string) any {
+	if s == "" {
+		return nil
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	return s
+}
+
+// Preload loads modelName into memory without generating anything, so the
+// first real request doesn't pay the load time.
+func (d *OllamaDescriber) Preload(ctx context.Context, modelName string) error {
+	payload := map[string]any{"model": modelName}
+	if d.KeepAlive != nil {
+		payload["keep_alive"] = d.KeepAlive
+	}
+	jsonData, _ := json.Marshal(payload)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", d.Host+"/api/generate", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("ollama status %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+func (d *OllamaDescriber) Describe(ctx context.Context, prompt string, images []string, modelName string) (string, error) {
+	payload := ChatRequest{
+		Model:     modelName,
+		Stream:    d.Stream,
+		KeepAlive: d.KeepAlive,
+		Messages: []Message{
+			{
+				Role:    "user",
+				Content: prompt,
+				Images:  images,
+			},
+		},
+		Options: ollamaOptions(),
+	}
+	if JSONSchema {
+		payload.Format = structuredSchema
+	} else if Structured {
+		payload.Format = "json"
+	}
+	if SystemPrompt != "" {
+		payload.Messages = append([]Message{{Role: "system", Content: SystemPrompt}}, payload.Messages...)
+	}
+
+	jsonData, _ := json.Marshal(payload)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", d.Host+"/api/chat", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return "", &retryableError{err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		err := fmt.Errorf("ollama status %d: %s", resp.StatusCode, string(body))
+		if resp.StatusCode >= 500 {
+			return "", &retryableError{err}
+		}
+		return "", err
+	}
+
+	if d.Stream {
+		return d.readStream(resp.Body)
+	}
+
+	var response ChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		// A truncated body usually means the connection dropped mid-response.
+		return "", &retryableError{err}
+	}
+
+	return response.Message.Content, nil
+}
+
+// readStream assembles the content of a streamed /api/chat response.
+func (d *OllamaDescriber) readStream(body io.Reader) (string, error) {
+	var content strings.Builder
+	dec := json.NewDecoder(body)
+	for {
+		var chunk ChatResponse
+		if err := dec.Decode(&chunk); err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("stream ended before the response was complete")
+			}
+			return "", &retryableError{err}
+		}
+		if chunk.Error != "" {
+			return "", fmt.Errorf("ollama stream error: %s", chunk.Error)
+		}
+		content.WriteString(chunk.Message.Content)
+		if d.OnToken != nil && chunk.Message.Content != "" {
+			d.OnToken(chunk.Message.Content)
+		}
+		if chunk.Done {
+			if d.OnToken != nil {
+				d.OnToken("\n")
+			}
+			return content.String(), nil
+		}
+	}
+}
+
+// OpenAIDescriber talks to an OpenAI-compatible /chat/completions endpoint
+// (OpenAI, vLLM, LM Studio, ...). Images are sent inline as data URLs.
+type OpenAIDescriber struct {
+	Client  *http.Client
+	BaseURL string
+	APIKey  string
+}
+
+type OpenAIChatRequest struct {
+	Model       string          `json:"model"`
+	Messages    []OpenAIMessage `json:"messages"`
+	MaxTokens   int             `json:"max_tokens,omitempty"`
+	Temperature *float64        `json:"temperature,omitempty"`
+	TopP        *float64        `json:"top_p,omitempty"`
+	Seed        *int            `json:"seed,omitempty"`
+	// ResponseFormat carries the JSON schema with -json-schema.
+	ResponseFormat any `json:"response_format,omitempty"`
+}
+
+type OpenAIMessage struct {
+	Role    string              `json:"role"`
+	Content []OpenAIContentPart `json:"content"`
+}
+
+type OpenAIContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *OpenAIImageURL `json:"image_url,omitempty"`
+}
+
+type OpenAIImageURL struct {
+	URL string `json:"url"`
+}
+
+type OpenAIChatResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+}
+
+func (d *OpenAIDescriber) Describe(ctx context.Context, prompt string, images []string, modelName string) (string, error) {
+	content := []OpenAIContentPart{{Type: "text", Text: prompt}}
+	for _, img := range images {
+		content = append(content, OpenAIContentPart{Type: "image_url", ImageURL: &OpenAIImageURL{URL: "data:" + imageMIME(img) + ";base64," + img}})
+	}
+	payload := OpenAIChatRequest{
+		Model:       modelName,
+		Messages:    []OpenAIMessage{{Role: "user", Content: content}},
+		MaxTokens:   NumPredict,
+		Temperature: Temperature,
+		TopP:        TopP,
+		Seed:        Seed,
+	}
+	if JSONSchema {
+		payload.ResponseFormat = map[string]any{
+			"type":        "json_schema",
+			"json_schema": map[string]any{"name": "image_description", "schema": structuredSchema},
+		}
+	}
+	if SystemPrompt != "" {
+		system := OpenAIMessage{Role: "system", Content: []OpenAIContentPart{{Type: "text", Text: SystemPrompt}}}
+		payload.Messages = append([]OpenAIMessage{system}, payload.Messages...)
+	}
+
+	jsonData, _ := json.Marshal(payload)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", d.BaseURL+"/chat/completions", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if d.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+d.APIKey)
+	}
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return "", &retryableError{err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		err := fmt.Errorf("openai status %d: %s", resp.StatusCode, string(body))
+		// 429 is the usual rate-limit response from hosted APIs.
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return "", &retryableError{err}
+		}
+		return "", err
+	}
+
+	var response OpenAIChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", &retryableError{err}
+	}
+	if len(response.Choices) == 0 {
+		return "", fmt.Errorf("openai response contained no choices")
+	}
+
+	return response.Choices[0].Message.Content, nil
+}
+
+// imageMIME returns the MIME type of a base64-encoded image: WebP (sent as-is
+// with -thumbnail-format WEBP) or JPEG (everything else is converted).
+func imageMIME(base64Image string) string {
+	if strings.HasPrefix(base64Image, "UklGR") { // "RIFF"
+		return "image/webp"
+	}
+	return "image/jpeg"
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Embed stores a text embedding of every saved description, computed with
+// EmbedModel in Ollama (-embed, -embed-model), for semantic search over the
+// descriptions.
+var (
+	Embed      bool
+	EmbedModel string
+)
+
+// embeddingTable holds the description embeddings. Immich's own
+// smart_search embeddings are CLIP image vectors of a fixed size, which a text
+// model's vectors can't be mixed with, so they get a table of their own.
+const embeddingTable = "immich_analyze_embedding"
+
+// embeddingVector is set when embeddingTable stores pgvector vectors (the
+// extension Immich installs), rather than plain real arrays.
+var embeddingVector bool
+
+// ensureEmbeddingTable creates embeddingTable if it doesn't exist yet, using
+// the pgvector type when it is available.
+func ensureEmbeddingTable(ctx context.Context, conn *pgxpool.Pool) error {
+	var colType string
+	err := conn.QueryRow(ctx, `
+		SELECT COALESCE((SELECT format_type(atttypid, atttypmod) FROM pg_attribute
+			WHERE attrelid = to_regclass($1) AND attname = 'embedding'), '')
+	`, embeddingTable).Scan(&colType)
+	if err != nil {
+		return err
+	}
+	if colType == "" {
+		var hasVector bool
+		if err := conn.QueryRow(ctx, `SELECT to_regtype('vector') IS NOT NULL`).Scan(&hasVector); err != nil {
+			return err
+		}
+		colType = "real[]"
+		if hasVector {
+			colType = "vector"
+		}
+		_, err = conn.Exec(ctx, `
+			CREATE TABLE IF NOT EXISTS `+embeddingTable+` (
+				"assetId"   uuid PRIMARY KEY REFERENCES asset(id) ON DELETE CASCADE,
+				model       text NOT NULL,
+				embedding   `+colType+` NOT NULL,
+				"updatedAt" timestamptz NOT NULL DEFAULT now()
+			)
+		`)
+		if err != nil {
+			return err
+		}
+	}
+	embeddingVector = colType != "real[]"
+	return nil
+}
+
+// embedText returns the embedding of text from Ollama's /api/embed.
+func embedText(ctx context.Context, text string) ([]float64, error) {
+	jsonData, _ := json.Marshal(map[string]any{"model": EmbedModel, "input": text})
+	req, err := http.NewRequestWithContext(ctx, "POST", OllamaHost+"/api/embed", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: OllamaTimeout, Transport: httpTransport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("ollama status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+
+	var out struct {
+		Embeddings [][]float64 `json:"embeddings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	if len(out.Embeddings) == 0 || len(out.Embeddings[0]) == 0 {
+		return nil, fmt.Errorf("ollama returned no embedding (is %s an embedding model?)", EmbedModel)
+	}
+	return out.Embeddings[0], nil
+}
+
+// saveEmbedding computes and stores the embedding of a saved description.
+func saveEmbedding(ctx context.Context, conn *pgxpool.Pool, assetID, desc string) error {
+	vec, err := embedText(ctx, desc)
+	if err != nil {
+		return err
+	}
+	// Both vector and real[] accept a text literal; they only differ in the brackets.
+	parts := make([]string, len(vec))
+	for i, v := range vec {
+		parts[i] = strconv.FormatFloat(v, 'g', -1, 32)
+	}
+	literal := "{" + strings.Join(parts, ",") + "}"
+	cast := "real[]"
+	if embeddingVector {
+		literal = "[" + strings.Join(parts, ",") + "]"
+		cast = "vector"
+	}
+	_, err = conn.Exec(ctx, `
+		INSERT INTO `+embeddingTable+` ("assetId", model, embedding, "updatedAt")
+		VALUES ($1, $2, $3::text::`+cast+`, now())
+		ON CONFLICT ("assetId") DO UPDATE SET model = EXCLUDED.model, embedding = EXCLUDED.embedding, "updatedAt" = EXCLUDED."updatedAt"
+	`, assetID, EmbedModel, literal)
+	return err
+}
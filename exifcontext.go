@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// UseExifContext adds the capture date and location to the prompt.
+var UseExifContext bool
+
+// exifContext returns a sentence describing when and where the asset was
+// taken, from the EXIF data Immich has stored (including its own reverse
+// geocoding), or "" if nothing is known.
+func exifContext(ctx context.Context, conn *pgxpool.Pool, assetID string) (string, error) {
+	var taken *time.Time
+	var timeZone, city, state, country *string
+	var lat, lon *float64
+	err := conn.QueryRow(ctx, `
+		SELECT "dateTimeOriginal", "timeZone", latitude, longitude, city, state, country
+		FROM asset_exif
+		WHERE "assetId" = $1
+	`, assetID).Scan(&taken, &timeZone, &lat, &lon, &city, &state, &country)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Some imports have no EXIF row at all.
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var parts []string
+	if taken != nil {
+		t := *taken
+		if timeZone != nil {
+			if loc, err := time.LoadLocation(*timeZone); err == nil {
+				t = t.In(loc)
+			}
+		}
+		parts = append(parts, "on "+t.Format("2 January 2006"))
+	}
+
+	var place []string
+	for _, s := range []*string{city, state, country} {
+		if s != nil && *s != "" {
+			place = append(place, *s)
+		}
+	}
+	switch {
+	case len(place) > 0:
+		parts = append(parts, "near "+strings.Join(place, ", "))
+	case lat != nil && lon != nil:
+		parts = append(parts, fmt.Sprintf("at coordinates %.4f, %.4f", *lat, *lon))
+	}
+
+	if len(parts) == 0 {
+		return "", nil
+	}
+	return "This photo was taken " + strings.Join(parts, " ") + ". ", nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// heifSupported and avifSupported are set when the decoder for the format is
+// compiled in (-tags heif, -tags avif).
+var heifSupported, avifSupported bool
+
+// isobmffBrands returns the major and compatible brands of an ISO base media
+// file (the container of HEIF and AVIF images), or nil for other data.
+func isobmffBrands(data []byte) []string {
+	if len(data) < 16 || string(data[4:8]) != "ftyp" {
+		return nil
+	}
+	size := int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	size = min(size, len(data))
+	brands := []string{string(data[8:12])}
+	// Skip the minor version; the compatible brands fill the rest of the box.
+	for i := 16; i+4 <= size; i += 4 {
+		brands = append(brands, string(data[i:i+4]))
+	}
+	return brands
+}
+
+// unsupportedFormat explains why an image that image.Decode rejected can't be
+// read, if it is a format we know about but can't decode in this build.
+func unsupportedFormat(data []byte) error {
+	brands := isobmffBrands(data)
+	if brands == nil {
+		return nil
+	}
+	if !avifSupported && (slices.Contains(brands, "avif") || slices.Contains(brands, "avis")) {
+		return fmt.Errorf("AVIF image, but this build has no AVIF decoder (rebuild with -tags avif, see README)")
+	}
+	if !heifSupported && slices.ContainsFunc(brands, func(b string) bool {
+		return slices.Contains([]string{"heic", "heix", "heim", "heis", "hevc", "hevx"}, b)
+	}) {
+		return fmt.Errorf("HEIC/HEIF image, but this build has no HEIF decoder (rebuild with -tags heif, see README)")
+	}
+	return nil
+}
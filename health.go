@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// HealthAddr is the listen address for the health endpoints; empty disables them.
+var HealthAddr string
+
+// startHealthServer serves liveness and readiness probes on addr in the
+// background:
+//
+//	GET /healthz  200 while the process is running
+//	GET /readyz   200 if the database (Immich with -source api) and the model
+//	              backend respond, 503 otherwise
+func startHealthServer(addr string, conn *pgxpool.Pool) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		down := map[string]string{}
+		if conn == nil {
+			if err := checkImmichAPI(ctx); err != nil {
+				down["immich"] = err.Error()
+			}
+		} else if err := conn.Ping(ctx); err != nil {
+			down["database"] = err.Error()
+		}
+		if _, err := checkBackend(ctx); err != nil {
+			down[Backend] = err.Error()
+		}
+		if len(down) > 0 {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "unavailable", "down": down})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+	})
+	go http.Serve(ln, mux)
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build heif
+
+package main
+
+import (
+	"image"
+
+	"github.com/jdeng/goheif"
+)
+
+// HEIF decoding uses libde265 through cgo, so it is only built with -tags heif:
+//
+//	CGO_ENABLED=1 go build -tags heif
+func init() {
+	for _, brand := range []string{"heic", "heix", "heim", "heis", "mif1"} {
+		image.RegisterFormat("heif", "????ftyp"+brand, goheif.Decode, goheif.DecodeConfig)
+	}
+	heifSupported = true
+}
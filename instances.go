@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// runInstances processes each configured Immich instance in turn, with the
+// shared options from the command line. In watch mode the whole list is
+// processed again after every interval.
+func runInstances(ctx context.Context, instances []instanceConfig, defaults instanceConfig) {
+	watch := WatchMode
+	// Each pass runs every instance to completion before moving on.
+	WatchMode = false
+	checkpointBase, reportBase, cacheBase := CheckpointFile, ErrorReport, CacheFile
+
+	for {
+		for _, inst := range instances {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := applyInstance(inst, defaults); err != nil {
+				log.Fatalf("Instance %s: %v", inst.Name, err)
+			}
+			CheckpointFile = instancePath(checkpointBase, inst.Name)
+			if reportBase != "" {
+				ErrorReport = instancePath(reportBase, inst.Name)
+			}
+			if cacheBase != "" {
+				CacheFile = instancePath(cacheBase, inst.Name)
+			}
+			textf("\n=== Instance %s (%s) ===\n", inst.Name, ImmichBaseURL)
+			Logger.Info("instance started", "instance", inst.Name, "immich_url", ImmichBaseURL)
+			runNormal(ctx)
+		}
+		if !watch || ctx.Err() != nil {
+			return
+		}
+		textf("Sleeping for %v... (Ctrl+C to stop)\n", WatchInterval)
+		sleepCtx(ctx, WatchInterval)
+	}
+}
+
+// applyInstance points the global connection settings at inst, filling empty
+// fields from defaults.
+func applyInstance(inst, defaults instanceConfig) error {
+	pick := func(v, fallback string) string {
+		if v != "" {
+			return v
+		}
+		return fallback
+	}
+	host := pick(inst.Host, defaults.Host)
+	ImmichHostIP = host
+	ImmichAPIKey = pick(inst.APIKey, defaults.APIKey)
+
+	if inst.ImmichURL != "" {
+		u, err := url.Parse(inst.ImmichURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid immich-url %q", inst.ImmichURL)
+		}
+		ImmichBaseURL = strings.TrimRight(inst.ImmichURL, "/")
+	} else {
+		ImmichBaseURL = fmt.Sprintf("http://%s:2283", host)
+	}
+
+	// As in single-instance mode, the database lives on the Immich host unless set.
+	dbHost := pick(inst.DBHost, host)
+	user, port, name := pick(inst.DBUser, defaults.DBUser), pick(inst.DBPort, defaults.DBPort), pick(inst.DBName, defaults.DBName)
+	PostgresURL = postgresURL(user, pick(inst.DBPassword, defaults.DBPassword), dbHost, port, name)
+	PostgresURLRedacted = postgresURL(user, "****", dbHost, port, name)
+
+	Models = (&stringList{}).parse(pick(inst.Model, defaults.Model))
+	if len(Models) == 0 {
+		return fmt.Errorf("no model given")
+	}
+	return nil
+}
+
+// instancePath derives a per-instance file name from path, e.g.
+// checkpoint.json -> checkpoint-home.json, so instances don't share state.
+func instancePath(path, name string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + name + ext
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Language is the language the descriptions should be written in; empty
+// leaves it to the model (usually English).
+var Language string
+
+// languageInstruction returns the sentence appended to the prompt for -language.
+func languageInstruction() string {
+	if Language == "" {
+		return ""
+	}
+	s := fmt.Sprintf(" Respond entirely in %s.", Language)
+	if Structured {
+		s += ` Keep the JSON field names in English.`
+	} else if ExtractTags {
+		// splitDescription looks for an English heading.
+		s += ` Keep the heading "Keywords:" in English.`
+	}
+	return s
+}
+
+var englishWords = map[string]bool{
+	"the": true, "and": true, "with": true, "of": true, "is": true, "are": true,
+	"in": true, "on": true, "a": true, "an": true, "this": true, "image": true,
+}
+
+// looksEnglish is a rough check that text is English: at least a fifth of its
+// words are common English function words. Short texts are never flagged.
+func looksEnglish(text string) bool {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z') && r < 0x80
+	})
+	if len(words) < 20 {
+		return false
+	}
+	n := 0
+	for _, w := range words {
+		if englishWords[w] {
+			n++
+		}
+	}
+	return n*5 >= len(words)
+}
+
+// wantsEnglish reports whether -language asks for English anyway.
+func wantsEnglish() bool {
+	l := strings.ToLower(Language)
+	return l == "" || l == "en" || strings.HasPrefix(l, "english")
+}
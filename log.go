@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// LogFormat selects the output style: "text" prints human-readable progress
+// lines, "json" emits one structured slog record per event instead.
+var LogFormat string
+
+// Logger receives the structured events. It discards everything in text mode,
+// so callers can log unconditionally alongside their textf output.
+var Logger = slog.New(slog.DiscardHandler)
+
+// setupLogging configures Logger for the selected -log-format. -verbose maps to
+// the debug level. With -output json the events go to stderr.
+func setupLogging() {
+	if LogFormat != "json" {
+		return
+	}
+	level := slog.LevelInfo
+	if VerboseMode {
+		level = slog.LevelDebug
+	}
+	out := os.Stdout
+	if Output == "json" {
+		out = os.Stderr
+	}
+	Logger = slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level}))
+}
+
+// textf prints a human-readable progress line. It is silent in json mode,
+// where the equivalent information goes through Logger, and with -output json.
+func textf(format string, a ...any) {
+	if LogFormat == "json" || Output == "json" {
+		return
+	}
+	if progress != nil {
+		progress.print(fmt.Sprintf(format, a...))
+		return
+	}
+	fmt.Printf(format, a...)
+}
@@ -1,25 +1,40 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
-	"encoding/json"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"image"
+	_ "image/gif"
 	"image/jpeg"
 	_ "image/png"
 	"io"
 	"log"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 	_ "golang.org/x/image/webp"
+	"golang.org/x/time/rate"
 )
 
 // --- CONFIGURATION VARS ---
@@ -27,34 +42,168 @@ var ImmichHostIP string
 var ImmichAPIKey string
 var OllamaHost string
 var OllamaModel string
+var Models []string // OllamaModel split on commas: primary model first, then fallbacks
+var Backend string
+var OpenAIBaseURL string
+var OpenAIAPIKey string
 var BenchmarkMode bool
 var VerboseMode bool
 var WatchMode bool
+var MaxDuration time.Duration
 var WatchInterval time.Duration
+var Limit int
+var AdaptiveInterval bool
+var MaxWatchInterval time.Duration
+var MaxRetries int
+var OllamaTimeout time.Duration
+var DownloadTimeout time.Duration
+var MaxFailuresPerAsset int
+var Workers int
+var DownloadWorkers int
+var InferenceWorkers int
+var DBMaxConns int
+var ExtractTags bool
+var Stream bool
+var KeepAlive string
+var WarmUp bool
+var TrackModel bool
+var DryRun bool
+var SortOrder string
+var AssetIDs stringList
+var ExcludeTags stringList
+var ReprocessErrors string
+var Overwrite bool
+var OverwriteByModel string
+var SkipUnchanged bool
+var Album string
+var AlbumID string // resolved from Album at startup
+var Owner string
+var OwnerID string // resolved from Owner at startup
+var DateFrom time.Time
+var DateTo time.Time
+var SinceLastRun bool
+var CreatedAfter time.Time // from the checkpoint, with SinceLastRun
+var IncludeVideos bool
+var OnlyFavorites bool
+var FavoritesFirst bool
+var ImageSize string
+var ThumbnailFormat string
+var JPEGQuality int
+var MaxDimension int
+var AssumeYes bool
+var CheckpointFile string
+var NoCheckpoint bool
+var BatchSize int
+var CommitBatch int
+var BenchmarkCount int
+var BenchmarkModels []string
+var BenchmarkCSV string
+var LocalFile string
+var CountMode bool
+
+// stringList is a flag.Value that accepts repeated and/or comma-separated values.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = l.parse(value)
+	return nil
+}
+
+// parse appends the non-empty comma-separated values in value to l and returns the result.
+func (l *stringList) parse(value string) stringList {
+	out := *l
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Prompt sent with every image; resolved once at startup from -prompt / -prompt-file.
+var Prompt string
+
+// VideoPrompt replaces Prompt for videos when set (-video-prompt / -video-prompt-file).
+var VideoPrompt string
+
+// SystemPrompt is sent as a separate system message before the prompt and
+// image (-system-prompt); empty sends only the user message.
+var SystemPrompt string
+
+const DefaultPrompt = "Describe this image concisely. Then list 15 relevant keywords for search (objects, activities, setting, time, colors)."
 
 // Derived URLs
 var ImmichBaseURL string
 var PostgresURL string
+var PostgresURLRedacted string // safe for logs: password replaced with ****
+
+// RetryPolicy controls how generateDescription retries transient Ollama failures.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// retryableError marks a failure (network error or 5xx) that is worth retrying.
+type retryableError struct {
+	err error
+}
 
-// Structs
-type ChatRequest struct {
-	Model    string                 `json:"model"`
-	Messages []Message              `json:"messages"`
-	Stream   bool                   `json:"stream"`
-	Options  map[string]interface{} `json:"options"`
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// failureTracker counts failures per asset within a run so that assets which
+// keep failing are excluded from later scans instead of being retried forever.
+type failureTracker struct {
+	mu      sync.Mutex
+	counts  map[string]int
+	limit   int
+	missing []string // given up on because the thumbnail doesn't exist
+}
+
+func newFailureTracker(limit int) *failureTracker {
+	return &failureTracker{counts: make(map[string]int), limit: limit}
+}
+
+// record notes a failure for id and reports whether the asset is now permanently skipped.
+func (f *failureTracker) record(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.counts[id]++
+	return f.counts[id] >= f.limit
+}
+
+// giveUp permanently skips id for the rest of the run, regardless of the limit,
+// and remembers it as having no thumbnail.
+func (f *failureTracker) giveUp(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.counts[id] = f.limit
+	if !contains(f.missing, id) {
+		f.missing = append(f.missing, id)
+	}
 }
 
-type Message struct {
-	Role    string   `json:"role"`
-	Content string   `json:"content"`
-	Images  []string `json:"images,omitempty"`
+// noThumbnail returns the IDs skipped because Immich has no thumbnail for them.
+func (f *failureTracker) noThumbnail() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.missing...)
 }
 
-type ChatResponse struct {
-	Message struct {
-		Content string `json:"content"`
+// skipped returns the IDs that reached the failure limit.
+func (f *failureTracker) skipped() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := []string{}
+	for id, n := range f.counts {
+		if n >= f.limit {
+			ids = append(ids, id)
+		}
 	}
-	Done bool `json:"done"`
+	return ids
 }
 
 func main() {
@@ -78,304 +227,2321 @@ func main() {
 	// 3. Define Flags (override ENV)
 	flag.StringVar(&ImmichHostIP, "host", envImmichHost, "Immich Host IP")
 	flag.StringVar(&ImmichAPIKey, "key", envImmichKey, "Immich API Key")
+	var apiKeyFile, dbPasswordFile, openAIKeyFile string
+	flag.StringVar(&apiKeyFile, "immich-api-key-file", getEnv("IMMICH_API_KEY_FILE", ""), "Read the Immich API key from this file, e.g. a Docker secret (overrides -key)")
+	flag.StringVar(&dbPasswordFile, "db-password-file", getEnv("DB_PASS_FILE", ""), "Read the database password from this file, e.g. a Docker secret (overrides DB_PASS)")
+	flag.StringVar(&openAIKeyFile, "openai-api-key-file", getEnv("OPENAI_API_KEY_FILE", ""), "Read the OpenAI API key from this file (overrides OPENAI_API_KEY)")
+	var immichURL string
+	flag.StringVar(&immichURL, "immich-url", getEnv("IMMICH_URL", ""), "Full Immich base URL, e.g. https://photos.example.com (overrides -host for API calls)")
 	flag.StringVar(&OllamaHost, "ollama", envOllamaHost, "Ollama Server URL")
-	flag.StringVar(&OllamaModel, "model", envOllamaModel, "Ollama model to use")
+	flag.StringVar(&OllamaModel, "model", envOllamaModel, "Model to use; a comma-separated list is tried in order as fallbacks")
+	flag.StringVar(&Backend, "backend", getEnv("BACKEND", "ollama"), "Model API backend: ollama or openai")
+	flag.StringVar(&OpenAIBaseURL, "openai-url", getEnv("OPENAI_BASE_URL", "https://api.openai.com/v1"), "Base URL of the OpenAI-compatible API (backend=openai)")
 	
 	var intervalStr string
 	flag.StringVar(&intervalStr, "interval", envWatchInterval, "Watch interval (e.g. 1m, 1h)")
+	flag.BoolVar(&AdaptiveInterval, "adaptive-interval", false, "In watch mode, double the interval after each scan that finds nothing (up to -max-interval)")
+	// MAX_WATCH_INTERVAL pairs with WATCH_INTERVAL; flagEnv maps the flag to it for -config.
+	flag.DurationVar(&MaxWatchInterval, "max-interval", envDuration("MAX_WATCH_INTERVAL", 30*time.Minute), "Longest interval with -adaptive-interval")
 	flag.BoolVar(&WatchMode, "watch", false, "Run in watcher mode (poll for new images)")
+	flag.DurationVar(&ProgressInterval, "progress-interval", envDuration("PROGRESS_INTERVAL", time.Minute), "Print the throughput and estimated time remaining this often (0 = never)")
+	flag.IntVar(&Limit, "limit", envInt("LIMIT", 0), "Stop after describing this many images (0 = no limit)")
+	flag.DurationVar(&MaxDuration, "max-duration", envDuration("MAX_DURATION", 0), "Stop cleanly after this long (e.g. 4h), even if there is more work; 0 = no limit")
 	
 	flag.BoolVar(&BenchmarkMode, "benchmark", false, "Run benchmark mode")
 	flag.BoolVar(&VerboseMode, "verbose", false, "Print full description to terminal")
+	flag.DurationVar(&OllamaTimeout, "ollama-timeout", envDuration("OLLAMA_TIMEOUT", 5*time.Minute), "Timeout for a single model request (e.g. 90s, 5m)")
+	flag.DurationVar(&DownloadTimeout, "download-timeout", envDuration("DOWNLOAD_TIMEOUT", 15*time.Second), "Timeout for downloading one image from Immich (e.g. 5s, 1m)")
+	flag.IntVar(&MaxRetries, "max-retries", envInt("MAX_RETRIES", 3), "Retries per Ollama request on network errors / 5xx")
+	flag.IntVar(&MaxFailuresPerAsset, "max-failures-per-asset", envInt("MAX_FAILURES_PER_ASSET", 3), "Failures before an asset is skipped for the rest of the run")
+	flag.IntVar(&BatchSize, "batch-size", envInt("BATCH_SIZE", 100), "Number of images fetched per scan")
+	flag.IntVar(&CommitBatch, "commit-batch", envInt("COMMIT_BATCH", 10), "Number of descriptions saved per database transaction (1 = save each immediately)")
+	flag.IntVar(&BenchmarkCount, "benchmark-count", 5, "Number of images used in benchmark mode")
+	flag.StringVar(&BenchmarkCSV, "benchmark-csv", "", "Also write the raw benchmark timings to this CSV file")
+	var benchmarkModels string
+	flag.StringVar(&benchmarkModels, "benchmark-models", getEnv("BENCHMARK_MODELS", "qwen3-vl:latest,moondream:latest,minicpm-v:latest"), "Comma-separated models compared in benchmark mode")
+	var promptFile string
+	flag.StringVar(&Prompt, "prompt", getEnv("PROMPT", ""), "Custom prompt text (default: built-in description + keywords prompt)")
+	flag.StringVar(&promptFile, "prompt-file", getEnv("PROMPT_FILE", ""), "Read the prompt from this file")
+	flag.StringVar(&SystemPrompt, "system-prompt", getEnv("SYSTEM_PROMPT", ""), "System message sent before the prompt, setting tone and format (empty = none)")
+	var videoPromptFile string
+	flag.StringVar(&VideoPrompt, "video-prompt", getEnv("VIDEO_PROMPT", ""), "Prompt for video poster frames (default: -prompt, noting that the image is a video frame)")
+	flag.StringVar(&videoPromptFile, "video-prompt-file", getEnv("VIDEO_PROMPT_FILE", ""), "Read the video prompt from this file")
+	flag.StringVar(&KeepAlive, "keep-alive", getEnv("KEEP_ALIVE", ""), "How long Ollama keeps the model loaded after a request (e.g. 30m, -1 = forever; default: Ollama's own)")
+	flag.BoolVar(&PullModel, "pull-model", false, "Pull models that aren't installed in Ollama instead of failing the preflight check")
+	flag.BoolVar(&WarmUp, "warm-up", false, "Load the model into Ollama before processing the first image")
+	flag.BoolVar(&StripPreamble, "strip-preamble", false, "Remove chat-style lead-ins (\"Sure! Here is...:\") and markdown fences from the model output")
+	var outputRegex string
+	flag.StringVar(&outputRegex, "output-regex", getEnv("OUTPUT_REGEX", ""), "Keep only the part of the model output matched by this regex (its first capture group, if any)")
+	flag.IntVar(&MaxChars, "max-chars", envInt("MAX_CHARS", 0), "Truncate saved descriptions to N characters at a word boundary (0 = no limit)")
+	flag.IntVar(&MinChars, "min-chars", envInt("MIN_CHARS", 0), "Treat descriptions shorter than N characters as failures instead of saving them (0 = no minimum)")
+	flag.IntVar(&NumPredict, "num-predict", envInt("NUM_PREDICT", 500), "Maximum number of tokens the model may generate (0 = backend default)")
+	var temperatureStr, topPStr, seedStr string
+	flag.StringVar(&temperatureStr, "temperature", getEnv("TEMPERATURE", "0.1"), "Sampling temperature (empty = backend default)")
+	flag.StringVar(&topPStr, "top-p", getEnv("TOP_P", ""), "Nucleus sampling top_p (empty = backend default)")
+	flag.StringVar(&seedStr, "seed", getEnv("SEED", ""), "Random seed for reproducible output (empty = random)")
+	var modelOptionsFile string
+	flag.StringVar(&modelOptionsFile, "model-options-file", getEnv("MODEL_OPTIONS_FILE", ""), "JSON file with additional Ollama options (e.g. num_ctx, repeat_penalty), overriding the built-in ones")
+	flag.StringVar(&Language, "language", getEnv("DESCRIPTION_LANGUAGE", ""), "Language of the descriptions, e.g. German (added to the prompt)")
+	flag.BoolVar(&UseExifContext, "use-exif-context", false, "Tell the model when and where the photo was taken (EXIF date and location)")
+	flag.BoolVar(&Stream, "stream", false, "Stream Ollama responses (with -verbose and one worker, tokens are printed as they arrive)")
+	flag.BoolVar(&Structured, "structured", false, "Ask the model for JSON with the description and keywords as separate fields instead of parsing free text")
+	flag.BoolVar(&JSONSchema, "json-schema", false, "Constrain the model output with a JSON schema and retry once if it doesn't match (implies -structured)")
+	flag.BoolVar(&ExtractTags, "extract-tags", false, "Save keywords as Immich tags instead of in the description")
+	flag.Var(&AssetIDs, "asset-id", "Process only these asset IDs, overwriting existing descriptions (repeatable or comma-separated)")
+	flag.BoolVar(&Overwrite, "overwrite", false, "Also re-describe images that already have a description")
+	flag.BoolVar(&SkipUnchanged, "skip-unchanged", false, "With -overwrite, skip assets whose thumbnail hasn't changed since they were last described (implies -track-model)")
+	flag.BoolVar(&Embed, "embed", false, "Also store a text embedding of each saved description (for semantic search over descriptions)")
+	flag.StringVar(&EmbedModel, "embed-model", getEnv("EMBED_MODEL", "nomic-embed-text"), "Ollama embedding model for -embed")
+	flag.StringVar(&Sidecar, "sidecar", getEnv("SIDECAR", ""), "Also write each saved description to a sidecar file: json or xmp")
+	flag.StringVar(&SidecarDir, "sidecar-dir", getEnv("SIDECAR_DIR", "sidecars"), "Directory for -sidecar files")
+	flag.StringVar(&TargetColumn, "target-column", getEnv("TARGET_COLUMN", "description"), "Where to write descriptions: a column of asset_exif, or table.column for a separate table keyed by \"assetId\"")
+	flag.StringVar(&Source, "source", getEnv("SOURCE", "db"), "Where to list assets and write descriptions: db (Immich's Postgres database, fast) or api (Immich REST API only, no database access needed)")
+	flag.StringVar(&WriteVia, "write-via", getEnv("WRITE_VIA", "sql"), "How to save descriptions: sql (directly in the database, fast) or api (through the Immich API, so Immich updates its own derived data)")
+	flag.StringVar(&OverwriteByModel, "overwrite-by-model", "", "Re-describe only images whose description was generated by this model (needs -track-model data)")
+	flag.BoolVar(&AssumeYes, "yes", false, "Do not ask for confirmation (for -overwrite)")
+	flag.StringVar(&Album, "album", getEnv("ALBUM", ""), "Only process images in this album (name or ID)")
+	flag.StringVar(&NotInAlbum, "not-in-album", getEnv("NOT_IN_ALBUM", ""), "Skip images already in this album (name or ID)")
+	flag.BoolVar(&AddToAlbum, "add-to-album", false, "Add every described image to the -not-in-album album")
+	flag.Var(&ExcludeTags, "exclude-tag", "Never process assets with this Immich tag or a tag nested below it (repeatable or comma-separated)")
+	flag.StringVar(&Owner, "owner", getEnv("OWNER", ""), "Only process assets owned by this user (email or ID)")
+	var fromStr, toStr string
+	flag.StringVar(&fromStr, "from", getEnv("DATE_FROM", ""), "Only process images taken on or after this date (YYYY-MM-DD)")
+	flag.StringVar(&toStr, "to", getEnv("DATE_TO", ""), "Only process images taken on or before this date (YYYY-MM-DD)")
+	flag.BoolVar(&SinceLastRun, "since-last-run", false, "Only scan assets added since the last complete run (recorded in the checkpoint file)")
+	flag.BoolVar(&IncludeVideos, "include-videos", false, "Also describe videos (using their poster frame)")
+	flag.BoolVar(&OnlyFavorites, "only-favorites", false, "Only process assets marked as favorite")
+	flag.BoolVar(&FavoritesFirst, "favorites-first", false, "Process favorites before all other assets")
+	flag.BoolVar(&DescribeStacks, "describe-stacks", false, "Describe each stack as a whole: send several of its shots in one request and save the description to the primary asset")
+	flag.IntVar(&StackImages, "stack-images", envInt("STACK_IMAGES", 4), "With -describe-stacks, the most images of a stack sent in one request")
+	flag.BoolVar(&DedupeBursts, "dedupe-bursts", false, "Describe one shot of each burst and copy its description to the other shots")
+	flag.IntVar(&PHashReuseThreshold, "phash-reuse-threshold", envInt("PHASH_REUSE_THRESHOLD", 0), "Reuse the description of an already described image whose perceptual hash differs in at most this many bits (0 = off; implies -track-model)")
+	flag.DurationVar(&BurstWindow, "burst-window", envDuration("BURST_WINDOW", 2*time.Second), "With -dedupe-bursts, the longest time between two shots of the same burst")
+	flag.StringVar(&ThumbnailFormat, "thumbnail-format", getEnv("THUMBNAIL_FORMAT", "JPEG"), "Format Immich returns thumbnails in: JPEG or WEBP (smaller; sent as-is to the openai backend)")
+	flag.StringVar(&ImageSize, "image-size", getEnv("IMAGE_SIZE", "thumbnail"), "Image sent to the model: thumbnail (small, fast) or preview (higher resolution)")
+	flag.IntVar(&JPEGQuality, "jpeg-quality", envInt("JPEG_QUALITY", 90), "JPEG quality (1-100) used when re-encoding images")
+	flag.IntVar(&MaxDimension, "max-dimension", envInt("MAX_DIMENSION", 0), "Downscale images so the longest side is at most N pixels (0 = no limit)")
+	flag.StringVar(&SortOrder, "order", getEnv("SORT_ORDER", "newest"), "Processing order by creation date: newest or oldest")
+	flag.BoolVar(&TrackModel, "track-model", false, "Record the generating model and time for each description in the "+metaTable+" table")
+	flag.BoolVar(&DryRun, "dry-run", false, "Generate descriptions but do not write anything to Immich")
+	flag.IntVar(&DBRetries, "db-retries", envInt("DB_RETRIES", 5), "Retries for a description write while the database is unreachable")
+	flag.IntVar(&DBReconnectAttempts, "db-reconnect-attempts", envInt("DB_RECONNECT_ATTEMPTS", 20), "Reconnect attempts in watch mode before giving up on an unreachable database")
+	flag.IntVar(&DBMaxConns, "db-max-conns", envInt("DB_MAX_CONNS", 4), "Maximum number of Postgres connections in the pool")
+	flag.StringVar(&CheckpointFile, "checkpoint-file", getEnv("CHECKPOINT_FILE", "immich-analyze-checkpoint.json"), "Path of the resume checkpoint file")
+	flag.BoolVar(&NoCheckpoint, "no-checkpoint", false, "Do not read or write the checkpoint file")
+	flag.StringVar(&CacheFile, "cache-file", getEnv("CACHE_FILE", "immich-analyze-cache.jsonl"), "Log generated descriptions here before saving, and save leftovers on the next start (empty disables)")
+	flag.StringVar(&ProxyURL, "proxy", getEnv("PROXY", ""), "HTTP proxy for Immich and model requests (default: from HTTP_PROXY/HTTPS_PROXY)")
+	flag.BoolVar(&InsecureTLS, "insecure", false, "Do not verify HTTPS certificates (self-signed Immich or API endpoints)")
+	flag.Float64Var(&ImmichRPS, "immich-rps", envFloat("IMMICH_RPS", 0), "Maximum thumbnail downloads per second from Immich (0 = unlimited)")
+	flag.StringVar(&ImmichAuthHeader, "immich-auth-header", getEnv("IMMICH_AUTH_HEADER", ""), "Extra header for Immich requests, e.g. \"Authorization: Bearer <token>\" for an authenticating proxy")
+	flag.StringVar(&ImmichBasicAuth, "immich-basic-auth", getEnv("IMMICH_BASIC_AUTH", ""), "user:password for HTTP Basic Auth on Immich requests, for an authenticating proxy")
+	flag.StringVar(&CACertFile, "ca-cert", getEnv("CA_CERT", ""), "PEM file with additional CA certificates to trust for HTTPS")
+	flag.StringVar(&LocalFile, "file", "", "Describe this local image file and print the result (no Immich or database access)")
+	flag.BoolVar(&CountMode, "count", false, "Print how many assets still need a description (respecting the filters) and exit")
+	flag.StringVar(&ServeAddr, "serve", getEnv("SERVE_ADDR", ""), "Run as an HTTP API server on this address (e.g. :8080) instead of processing once")
+	flag.StringVar(&WebhookURL, "webhook-url", getEnv("WEBHOOK_URL", ""), "POST a JSON summary to this URL when a run (or a watch cycle that did work) completes")
+	flag.StringVar(&DiscordWebhookURL, "notify-discord", getEnv("NOTIFY_DISCORD", ""), "Discord webhook URL for a formatted run summary")
+	flag.StringVar(&SlackWebhookURL, "notify-slack", getEnv("NOTIFY_SLACK", ""), "Slack incoming webhook URL for a formatted run summary")
+	flag.StringVar(&MetricsAddr, "metrics-addr", getEnv("METRICS_ADDR", ""), "Serve Prometheus metrics on this address (e.g. :9090); empty disables")
+	flag.StringVar(&HealthAddr, "health-addr", getEnv("HEALTH_ADDR", ""), "Serve /healthz and /readyz probes on this address (e.g. :8081); empty disables")
+	flag.StringVar(&LogFormat, "log-format", getEnv("LOG_FORMAT", "text"), "Output format: text or json (one JSON event per line)")
+	flag.StringVar(&Output, "output", getEnv("OUTPUT", "text"), "Run output: text, or json for a single JSON report of all assets at the end (for CI)")
+	flag.StringVar(&ReprocessErrors, "reprocess-errors", "", "Retry the images listed in this -error-report file and rewrite it with those that still fail")
+	flag.StringVar(&ErrorReport, "error-report", getEnv("ERROR_REPORT", ""), "Write the images that failed in this run to this JSON file")
+	flag.BoolVar(&Quiet, "quiet", false, "Show a progress bar instead of one line per image")
+	flag.IntVar(&Workers, "workers", envInt("WORKERS", 1), "Number of assets processed concurrently (default for -download-workers and -inference-workers)")
+	flag.IntVar(&DownloadWorkers, "download-workers", envInt("DOWNLOAD_WORKERS", 0), "Number of concurrent thumbnail downloads (default: -workers)")
+	flag.IntVar(&InferenceWorkers, "inference-workers", envInt("INFERENCE_WORKERS", 0), "Number of concurrent model requests (default: -workers)")
+	flag.StringVar(&ConfigFile, "config", getEnv("CONFIG_FILE", ""), "YAML config file with default options and/or several Immich instances to process")
 	flag.Parse()
 
-	var err error
-	WatchInterval, err = time.ParseDuration(intervalStr)
-	if err != nil {
-		log.Fatalf("Invalid interval format: %v", err)
+	var cfg configFile
+	if ConfigFile != "" {
+		loaded, err := loadConfig(ConfigFile)
+		if err != nil {
+			log.Fatalf("Failed to read -config: %v", err)
+		}
+		if err := loaded.apply(); err != nil {
+			log.Fatal(err)
+		}
+		cfg = *loaded
 	}
+	envDBUser = cfg.env("DB_USER", envDBUser)
+	envDBPass = cfg.env("DB_PASS", envDBPass)
+	envDBName = cfg.env("DB_NAME", envDBName)
+	envDBPort = cfg.env("DB_PORT", envDBPort)
 
-	// 4. Construct Derived URLs
-	ImmichBaseURL = fmt.Sprintf("http://%s:2283", ImmichHostIP)
-	// Use envDBHost for Postgres, but if user overrides -host flag, should we respect that for DB too if DB_HOST wasn't explicitly set?
-	// Simplest logic: If DB_HOST is set in env, use it. If not, use the final ImmichHostIP (which might be from flag).
-	
-	// Re-evaluate DB Host logic after flags
-	finalDBHost := envDBHost
-	if os.Getenv("DB_HOST") == "" {
-		// If explicit DB_HOST wasn't provided in env, assume it follows the Immich Host (even if changed via flag)
-		finalDBHost = ImmichHostIP
+	if len(ExcludeTags) == 0 {
+		ExcludeTags = ExcludeTags.parse(getEnv("EXCLUDE_TAG", ""))
 	}
 
-	PostgresURL = fmt.Sprintf("postgres://%s:%s@%s:%s/%s", envDBUser, envDBPass, finalDBHost, envDBPort, envDBName)
+	Models = (&stringList{}).parse(OllamaModel)
+	BenchmarkModels = (&stringList{}).parse(benchmarkModels)
+	if BenchmarkMode && len(BenchmarkModels) == 0 {
+		log.Fatal("No benchmark models given: set -benchmark-models")
+	}
+	if len(Models) == 0 {
+		log.Fatal("No model given: set -model or OLLAMA_MODEL")
+	}
 
-	if BenchmarkMode {
-		runBenchmark()
+	OpenAIAPIKey = cfg.env("OPENAI_API_KEY", getEnv("OPENAI_API_KEY", ""))
+	loadSecretFile("immich-api-key-file", apiKeyFile, &ImmichAPIKey)
+	loadSecretFile("db-password-file", dbPasswordFile, &envDBPass)
+	loadSecretFile("openai-api-key-file", openAIKeyFile, &OpenAIAPIKey)
+	if Backend != "ollama" && Backend != "openai" {
+		log.Fatalf("Invalid -backend: %q (must be ollama or openai)", Backend)
+	}
+
+	if t, err := parseTarget(TargetColumn); err != nil {
+		log.Fatal(err)
 	} else {
-		runNormal()
+		target = t
+	}
+	if Source != "db" && Source != "api" {
+		log.Fatalf("Invalid -source: %q (must be db or api)", Source)
+	}
+	if WriteVia != "sql" && WriteVia != "api" {
+		log.Fatalf("Invalid -write-via: %q (must be sql or api)", WriteVia)
+	}
+	if WriteVia == "api" && !target.native() {
+		log.Fatal("-write-via api only writes Immich's own description field, it cannot be combined with -target-column")
+	}
+	if Source == "api" {
+		// These need Immich's database or the tables this tool keeps in it.
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{
+			{"track-model", TrackModel},
+			{"overwrite-by-model", OverwriteByModel != ""},
+			{"skip-unchanged", SkipUnchanged},
+			{"phash-reuse-threshold", PHashReuseThreshold != 0},
+			{"target-column", !target.native()},
+			{"owner", Owner != ""},
+			{"exclude-tag", len(ExcludeTags) > 0},
+			{"favorites-first", FavoritesFirst},
+			{"use-exif-context", UseExifContext},
+			{"embed", Embed},
+			{"sidecar", Sidecar != ""},
+			{"describe-stacks", DescribeStacks},
+			{"benchmark", BenchmarkMode},
+		} {
+			if f.set {
+				log.Fatalf("-%s is not available with -source api", f.name)
+			}
+		}
 	}
-}
 
-func getEnv(key, fallback string) string {
-	if value, ok := os.LookupEnv(key); ok {
-		return value
+	if KeepAlive != "" {
+		if _, err := strconv.Atoi(KeepAlive); err != nil {
+			if _, err := time.ParseDuration(KeepAlive); err != nil {
+				log.Fatalf("Invalid -keep-alive: %q (use a duration like 30m, or seconds, -1 = forever)", KeepAlive)
+			}
+		}
+	}
+	if Stream && Backend != "ollama" {
+		log.Fatal("-stream is only supported with the ollama backend")
+	}
+	if JSONSchema {
+		Structured = true
+	}
+	if Embed && EmbedModel == "" {
+		log.Fatal("-embed needs an -embed-model")
+	}
+	if PullModel && Backend != "ollama" {
+		log.Fatal("-pull-model is only supported with the ollama backend")
+	}
+	if modelOptionsFile != "" {
+		if Backend != "ollama" {
+			log.Fatal("-model-options-file is only supported with the ollama backend")
+		}
+		opts, err := loadModelOptions(modelOptionsFile)
+		if err != nil {
+			log.Fatalf("Invalid -model-options-file: %v", err)
+		}
+		ModelOptions = opts
 	}
-	return fallback
-}
 
-func runBenchmark() {
-	fmt.Println("--- BENCHMARK MODE ---")
-	models := []string{"qwen3-vl:latest", "moondream:latest", "minicpm-v:latest"}
-	
-	ctx := context.Background()
-	conn, err := pgx.Connect(ctx, PostgresURL)
-	if err != nil {
-		log.Fatal(fmt.Errorf("DB connect error: %v (URL: %s)", err, PostgresURL))
+	if LogFormat != "text" && LogFormat != "json" {
+		log.Fatalf("Invalid -log-format: %q (must be text or json)", LogFormat)
 	}
-	defer conn.Close(ctx)
+	if Output != "text" && Output != "json" {
+		log.Fatalf("Invalid -output: %q (must be text or json)", Output)
+	}
+	setupLogging()
 
-	// Get 5 images
-	query := `
-		SELECT a.id
-		FROM asset a
-		WHERE a.type = 'IMAGE'
-		ORDER BY a."createdAt" DESC
-		LIMIT 5
-	`
-	rows, err := conn.Query(ctx, query)
-	if err != nil {
+	if err := setupTransport(); err != nil {
 		log.Fatal(err)
 	}
-	
-	var assetIDs []string
-	for rows.Next() {
-		var id string
-		if err := rows.Scan(&id); err != nil {
-			log.Fatal(err)
-		}
-		assetIDs = append(assetIDs, id)
+	if InsecureTLS {
+		textf("WARNING: -insecure disables HTTPS certificate verification\n")
+		Logger.Warn("TLS certificate verification disabled")
 	}
-	rows.Close()
-	client := &http.Client{Timeout: 0}
 
-	for i, assetID := range assetIDs {
-		fmt.Printf("\n[%d/5] Image ID: %s\n", i+1, assetID)
-		
-		imgBytes, err := downloadThumbnail(assetID)
-		if err != nil {
-			fmt.Printf("Error downloading: %v\n", err)
+	if Quiet && VerboseMode {
+		log.Fatal("-quiet and -verbose are mutually exclusive")
+	}
+	for name, target := range map[string]string{"webhook-url": WebhookURL, "notify-discord": DiscordWebhookURL, "notify-slack": SlackWebhookURL} {
+		if target == "" {
 			continue
 		}
-		imgBytes, err = ensureJPEG(imgBytes)
+		if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Fatalf("Invalid -%s: %q (expected an http(s) URL)", name, target)
+		}
+	}
+	if MaxDuration < 0 {
+		log.Fatalf("Invalid -max-duration: %v (must be >= 0)", MaxDuration)
+	}
+	if OllamaTimeout <= 0 {
+		log.Fatalf("Invalid -ollama-timeout: %v (must be > 0)", OllamaTimeout)
+	}
+	if DownloadTimeout <= 0 {
+		log.Fatalf("Invalid -download-timeout: %v (must be > 0)", DownloadTimeout)
+	}
+	if MaxRetries < 0 {
+		log.Fatalf("Invalid -max-retries: %d (must be >= 0)", MaxRetries)
+	}
+	if OverwriteByModel != "" {
+		if WatchMode {
+			log.Fatal("-overwrite-by-model cannot be combined with -watch")
+		}
+		// Replacing descriptions by model only makes sense if the new ones are tracked too.
+		Overwrite = true
+		TrackModel = true
+	}
+	if SinceLastRun && NoCheckpoint {
+		log.Fatal("-since-last-run needs the checkpoint file, it cannot be combined with -no-checkpoint")
+	}
+	if SkipUnchanged {
+		if !Overwrite {
+			log.Fatal("-skip-unchanged only applies to -overwrite")
+		}
+		if ServeAddr != "" {
+			log.Fatal("-skip-unchanged cannot be combined with -serve")
+		}
+		// The hashes are stored in the model tracking table.
+		TrackModel = true
+	}
+	if Overwrite && WatchMode {
+		log.Fatal("-overwrite cannot be combined with -watch")
+	}
+	if ReprocessErrors != "" {
+		if len(AssetIDs) > 0 {
+			log.Fatal("-reprocess-errors cannot be combined with -asset-id")
+		}
+		failures, err := readErrorReport(ReprocessErrors)
 		if err != nil {
-			fmt.Printf("Error converting: %v\n", err)
-			continue
+			log.Fatalf("Failed to read -reprocess-errors: %v", err)
 		}
-		b64Image := base64.StdEncoding.EncodeToString(imgBytes)
-
-		for _, model := range models {
-			fmt.Printf("  Testing %s ... ", model)
-			start := time.Now()
-			
-			// Call generate with specific model
-			desc, err := generateDescription(client, b64Image, model)
-			duration := time.Since(start)
-
-			if err != nil {
-				fmt.Printf("FAILED (%v)\n", err)
-			} else {
-				fmt.Printf("DONE in %.2fs\n", duration.Seconds())
-				fmt.Printf("    -> Description: %s\n", desc)
+		if len(failures) == 0 {
+			textf("No failed images in %s, nothing to reprocess.\n", ReprocessErrors)
+			Logger.Info("nothing to reprocess", "path", ReprocessErrors)
+			if Output == "json" {
+				// An empty report, so the output is still a JSON document.
+				if err := (&runReport{}).write(runSummary{Models: Models, DryRun: DryRun}); err != nil {
+					log.Fatal(err)
+				}
 			}
+			return
+		}
+		for _, f := range failures {
+			AssetIDs = append(AssetIDs, f.AssetID)
+		}
+		reprocessFailures = failures
+		if ErrorReport == "" && !DryRun {
+			ErrorReport = ReprocessErrors
 		}
 	}
-	fmt.Println("\n--- BENCHMARK COMPLETE ---")
-}
-
-func runNormal() {
-	fmt.Printf("Using model: %s\n", OllamaModel)
-	ctx := context.Background()
-
-	fmt.Println("1. Connecting to DB...")
-	conn, err := pgx.Connect(ctx, PostgresURL)
-	if err != nil {
-		log.Fatal(fmt.Errorf("DB connect error: %v (URL: %s)", err, PostgresURL))
+	if LocalFile != "" && (WatchMode || BenchmarkMode || ServeAddr != "" || len(AssetIDs) > 0) {
+		log.Fatal("-file cannot be combined with -watch, -benchmark, -serve or -asset-id")
 	}
-	defer conn.Close(ctx)
-
-	ollamaHTTPClient := &http.Client{Timeout: 0}
-	totalProcessed := 0
-
-	for {
-		fmt.Println("2. Scanning for images (batch of 100)...")
-		query := `
-			SELECT a.id
-			FROM asset a
-			JOIN asset_exif ae ON a.id = ae."assetId"
-			WHERE (ae.description IS NULL OR ae.description = '')
-			AND a.type = 'IMAGE'
-			ORDER BY a."createdAt" DESC
-			LIMIT 100
-		`
-		rows, err := conn.Query(ctx, query)
+	if CountMode && (LocalFile != "" || WatchMode || BenchmarkMode || ServeAddr != "" || len(AssetIDs) > 0) {
+		log.Fatal("-count cannot be combined with -file, -watch, -benchmark, -serve or -asset-id")
+	}
+	if ServeAddr != "" && (WatchMode || BenchmarkMode || Quiet || len(AssetIDs) > 0) {
+		log.Fatal("-serve cannot be combined with -watch, -benchmark, -quiet or -asset-id")
+	}
+	if Output == "json" && (LocalFile != "" || CountMode || WatchMode || BenchmarkMode || ServeAddr != "") {
+		// The report is written once, at the end of a run.
+		log.Fatal("-output json cannot be combined with -file, -count, -watch, -benchmark or -serve")
+	}
+	ThumbnailFormat = strings.ToUpper(ThumbnailFormat)
+	if ThumbnailFormat != "JPEG" && ThumbnailFormat != "WEBP" {
+		log.Fatalf("Invalid -thumbnail-format: %q (must be JPEG or WEBP)", ThumbnailFormat)
+	}
+	if ImageSize != "thumbnail" && ImageSize != "preview" {
+		log.Fatalf("Invalid -image-size: %q (must be thumbnail or preview)", ImageSize)
+	}
+	if JPEGQuality < 1 || JPEGQuality > 100 {
+		log.Fatalf("Invalid -jpeg-quality: %d (must be 1-100)", JPEGQuality)
+	}
+	if MaxDimension < 0 {
+		log.Fatalf("Invalid -max-dimension: %d (must be >= 0)", MaxDimension)
+	}
+	if SortOrder != "newest" && SortOrder != "oldest" {
+		log.Fatalf("Invalid -order: %q (must be newest or oldest)", SortOrder)
+	}
+	if BatchSize < 1 {
+		log.Fatalf("Invalid -batch-size: %d (must be >= 1)", BatchSize)
+	}
+	if outputRegex != "" {
+		re, err := regexp.Compile(outputRegex)
 		if err != nil {
-			log.Fatal(err)
+			log.Fatalf("Invalid -output-regex: %v", err)
 		}
-		
-		var assetIDs []string
-		for rows.Next() {
-			var id string
-			if err := rows.Scan(&id); err != nil {
-				log.Fatal(err)
-			}
-			assetIDs = append(assetIDs, id)
+		OutputRegex = re
+	}
+	if MaxChars < 0 || (MaxChars > 0 && MaxChars < 10) {
+		log.Fatalf("Invalid -max-chars: %d (must be 0 or >= 10)", MaxChars)
+	}
+	if MinChars < 0 {
+		log.Fatalf("Invalid -min-chars: %d (must be >= 0)", MinChars)
+	}
+	if MaxChars > 0 && MinChars > MaxChars {
+		log.Fatalf("Invalid -min-chars: %d (must not exceed -max-chars %d)", MinChars, MaxChars)
+	}
+	if NumPredict < 0 {
+		log.Fatalf("Invalid -num-predict: %d (must be >= 0)", NumPredict)
+	}
+	if temperatureStr != "" {
+		v, err := strconv.ParseFloat(temperatureStr, 64)
+		if err != nil || v < 0 {
+			log.Fatalf("Invalid -temperature: %q (must be a number >= 0)", temperatureStr)
 		}
-		rows.Close()
-
-		if len(assetIDs) == 0 {
-			if WatchMode {
-				if totalProcessed > 0 {
-					fmt.Printf("All caught up! Processed %d images.\n", totalProcessed)
-					totalProcessed = 0
-				}
-				fmt.Printf("Sleeping for %v... (Ctrl+C to stop)\n", WatchInterval)
-				time.Sleep(WatchInterval)
-				continue
-			}
-
-			if totalProcessed == 0 {
-				fmt.Println("No images found to process.")
-			} else {
-				fmt.Printf("All done! Processed %d images in total.\n", totalProcessed)
-			}
-			break
+		Temperature = &v
+	}
+	if topPStr != "" {
+		v, err := strconv.ParseFloat(topPStr, 64)
+		if err != nil || v <= 0 || v > 1 {
+			log.Fatalf("Invalid -top-p: %q (must be > 0 and <= 1)", topPStr)
 		}
-
-		count := 0
-		batchSuccess := 0
-		for _, assetID := range assetIDs {
-			count++
-			totalProcessed++
-			fmt.Printf("[%d|Total:%d] Processing %s ", count, totalProcessed, assetID)
-
-			imgBytes, err := downloadThumbnail(assetID)
-			if err != nil {
-				if strings.Contains(err.Error(), "status 404") {
-					fmt.Printf("\n   [SKIP] Thumbnail not ready\n")
-				} else {
-					fmt.Printf("\n   [SKIP] Download error: %v\n", err)
-				}
-				continue
-			}
-
-			imgBytes, err = ensureJPEG(imgBytes)
-			if err != nil {
-				fmt.Printf("\n   [SKIP] Image conversion error: %v\n", err)
-				continue
-			}
-
-			b64Image := base64.StdEncoding.EncodeToString(imgBytes)
-
-			fmt.Print("... Sending to GPU ... ")
-			// Use global OllamaModel
-			desc, err := generateDescription(ollamaHTTPClient, b64Image, OllamaModel)
-			if err != nil {
-				fmt.Printf("\n   [FAIL] Ollama error: %v\n", err)
-				continue
-			}
-
-			_, err = conn.Exec(ctx, `UPDATE asset_exif SET description = $1 WHERE "assetId" = $2`, desc, assetID)
-			if err != nil {
-				fmt.Printf("\n   [ERR] DB Save error: %v\n", err)
-				continue
-			}
-			if VerboseMode {
-				fmt.Printf("Done! (%d chars)\nDescription: %s\n", len(desc), desc)
-			} else {
-				fmt.Printf("Done! (%d chars)\n", len(desc))
-			}
-			batchSuccess++
+		TopP = &v
+	}
+	if seedStr != "" {
+		v, err := strconv.Atoi(seedStr)
+		if err != nil {
+			log.Fatalf("Invalid -seed: %q (must be an integer)", seedStr)
 		}
-
-		// If we found images but processed none (e.g. all 404), sleep to avoid hammering
-		if len(assetIDs) > 0 && batchSuccess == 0 {
-			fmt.Println("Batch failed (waiting for thumbnails). Sleeping 30s...")
-			time.Sleep(30 * time.Second)
+		Seed = &v
+	}
+	if CommitBatch < 1 {
+		log.Fatalf("Invalid -commit-batch: %d (must be >= 1)", CommitBatch)
+	}
+	if BenchmarkCount < 1 {
+		log.Fatalf("Invalid -benchmark-count: %d (must be >= 1)", BenchmarkCount)
+	}
+	if Workers < 1 {
+		log.Fatalf("Invalid -workers: %d (must be >= 1)", Workers)
+	}
+	if DownloadWorkers < 0 || InferenceWorkers < 0 {
+		log.Fatal("Invalid -download-workers / -inference-workers (must be >= 1)")
+	}
+	if DownloadWorkers == 0 {
+		DownloadWorkers = Workers
+	}
+	if InferenceWorkers == 0 {
+		InferenceWorkers = Workers
+	}
+	if DBRetries < 0 {
+		log.Fatalf("Invalid -db-retries: %d (must be >= 0)", DBRetries)
+	}
+	if DBReconnectAttempts < 0 {
+		log.Fatalf("Invalid -db-reconnect-attempts: %d (must be >= 0)", DBReconnectAttempts)
+	}
+	if DBMaxConns < 1 {
+		log.Fatalf("Invalid -db-max-conns: %d (must be >= 1)", DBMaxConns)
+	}
+	if ImmichRPS < 0 {
+		log.Fatalf("Invalid -immich-rps: %v (must be >= 0)", ImmichRPS)
+	}
+	if ImmichRPS > 0 {
+		immichLimiter = rate.NewLimiter(rate.Limit(ImmichRPS), 1)
+	}
+	if PHashReuseThreshold < 0 || PHashReuseThreshold > 32 {
+		log.Fatalf("Invalid -phash-reuse-threshold: %d (must be between 0 and 32)", PHashReuseThreshold)
+	}
+	if PHashReuseThreshold > 0 {
+		// The hashes are stored in the model tracking table.
+		TrackModel = true
+	}
+	if DescribeStacks && StackImages < 1 {
+		log.Fatalf("Invalid -stack-images: %d (must be >= 1)", StackImages)
+	}
+	if DedupeBursts && BurstWindow <= 0 {
+		log.Fatalf("Invalid -burst-window: %v (must be > 0)", BurstWindow)
+	}
+	if Sidecar != "" {
+		if Sidecar != "json" && Sidecar != "xmp" {
+			log.Fatalf("Invalid -sidecar: %q (must be json or xmp)", Sidecar)
+		}
+		if err := os.MkdirAll(SidecarDir, 0o755); err != nil {
+			log.Fatalf("Failed to create -sidecar-dir: %v", err)
 		}
 	}
-}
+	if AddToAlbum && NotInAlbum == "" {
+		log.Fatal("-add-to-album needs -not-in-album to name the album")
+	}
+	if Limit < 0 {
+		log.Fatalf("Invalid -limit: %d (must be >= 0)", Limit)
+	}
+	if MaxFailuresPerAsset < 1 {
+		log.Fatalf("Invalid -max-failures-per-asset: %d (must be >= 1)", MaxFailuresPerAsset)
+	}
 
-func downloadThumbnail(id string) ([]byte, error) {
-	u := fmt.Sprintf("%s/api/assets/%s/thumbnail?format=JPEG", ImmichBaseURL, id)
-	req, err := http.NewRequest("GET", u, nil)
+	var err error
+	Prompt, err = resolvePrompt("prompt", Prompt, promptFile)
 	if err != nil {
-		return nil, err
+		log.Fatal(err)
+	}
+	Prompt += languageInstruction()
+	if Structured {
+		Prompt += structuredInstruction
+	}
+	if VideoPrompt != "" || videoPromptFile != "" {
+		VideoPrompt, err = resolvePrompt("video-prompt", VideoPrompt, videoPromptFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		VideoPrompt += languageInstruction()
+		if Structured {
+			VideoPrompt += structuredInstruction
+		}
 	}
-	req.Header.Set("x-api-key", ImmichAPIKey)
-	req.Header.Set("Accept", "application/octet-stream")
 
-	client := &http.Client{Timeout: 15 * time.Second}
-	resp, err := client.Do(req)
+	WatchInterval, err = time.ParseDuration(intervalStr)
 	if err != nil {
-		return nil, err
+		log.Fatalf("Invalid interval format: %v", err)
+	}
+	if AdaptiveInterval && MaxWatchInterval < WatchInterval {
+		log.Fatalf("Invalid -max-interval: %v (must be at least -interval, %v)", MaxWatchInterval, WatchInterval)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	if fromStr != "" {
+		if DateFrom, err = time.ParseInLocation(time.DateOnly, fromStr, time.Local); err != nil {
+			log.Fatalf("Invalid -from: %q (expected YYYY-MM-DD)", fromStr)
+		}
+	}
+	if toStr != "" {
+		if DateTo, err = time.ParseInLocation(time.DateOnly, toStr, time.Local); err != nil {
+			log.Fatalf("Invalid -to: %q (expected YYYY-MM-DD)", toStr)
+		}
+	}
+	if !DateFrom.IsZero() && !DateTo.IsZero() && DateTo.Before(DateFrom) {
+		log.Fatalf("Invalid date range: -to %s is before -from %s", toStr, fromStr)
 	}
-	return io.ReadAll(resp.Body)
-}
 
-func generateDescription(client *http.Client, base64Image string, modelName string) (string, error) {
-	payload := ChatRequest{
-		Model:  modelName,
-		Stream: false,
-		Messages: []Message{
-			{
-				Role:    "user",
-				Content: "Describe this image concisely. Then list 15 relevant keywords for search (objects, activities, setting, time, colors).",
-				Images:  []string{base64Image},
-			},
-		},
-		Options: map[string]interface{}{
-			"num_predict": 500,
-			"temperature": 0.1,
-		},
+	// 4. Construct Derived URLs
+	if immichURL != "" {
+		u, err := url.Parse(immichURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Fatalf("Invalid -immich-url: %q (expected e.g. https://photos.example.com)", immichURL)
+		}
+		ImmichBaseURL = strings.TrimRight(immichURL, "/")
+	} else {
+		ImmichBaseURL = fmt.Sprintf("http://%s:2283", ImmichHostIP)
+	}
+	// Use envDBHost for Postgres, but if user overrides -host flag, should we respect that for DB too if DB_HOST wasn't explicitly set?
+	// Simplest logic: If DB_HOST is set in env, use it. If not, use the final ImmichHostIP (which might be from flag).
+	
+	// Re-evaluate DB Host logic after flags
+	finalDBHost := envDBHost
+	if os.Getenv("DB_HOST") == "" {
+		// If explicit DB_HOST wasn't provided in env, use the config file, or assume it follows the Immich Host (even if changed via flag)
+		finalDBHost = cfg.env("DB_HOST", ImmichHostIP)
 	}
 
-	jsonData, _ := json.Marshal(payload)
+	PostgresURL = postgresURL(envDBUser, envDBPass, finalDBHost, envDBPort, envDBName)
+	PostgresURLRedacted = postgresURL(envDBUser, "****", finalDBHost, envDBPort, envDBName)
 
-	resp, err := client.Post(OllamaHost+"/api/chat", "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", err
+	instances := cfg.Instances
+	if len(instances) > 0 {
+		if LocalFile != "" || BenchmarkMode || ServeAddr != "" {
+			log.Fatal("Instances from -config can't be combined with -file, -benchmark or -serve")
+		}
+		if len(AssetIDs) > 0 || ReprocessErrors != "" {
+			log.Fatal("Instances from -config can't be combined with -asset-id or -reprocess-errors")
+		}
+		if HealthAddr != "" || CountMode {
+			log.Fatal("Instances from -config can't be combined with -health-addr or -count")
+		}
+		if Output == "json" {
+			log.Fatal("Instances from -config can't be combined with -output json")
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("ollama status %d: %s", resp.StatusCode, string(body))
+	if LocalFile != "" {
+		runFile(LocalFile)
+	} else if CountMode {
+		runCount()
+	} else if BenchmarkMode {
+		runBenchmark()
+	} else if ServeAddr != "" {
+		runServer()
+	} else if len(instances) > 0 {
+		runInstances(withMaxDuration(shutdownContext()), instances, instanceConfig{
+			Host:       ImmichHostIP,
+			APIKey:     ImmichAPIKey,
+			DBUser:     envDBUser,
+			DBPassword: envDBPass,
+			DBPort:     envDBPort,
+			DBName:     envDBName,
+			Model:      OllamaModel,
+		})
+	} else {
+		runNormal(withMaxDuration(shutdownContext()))
 	}
+}
 
-	var response ChatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return "", err
+// postgresURL builds a connection string, escaping the credentials so that
+// passwords containing characters like '@' or '/' still parse.
+func postgresURL(user, pass, host, port, name string) string {
+	u := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(user, pass),
+		Host:   net.JoinHostPort(host, port),
+		Path:   "/" + name,
 	}
-
-	return response.Message.Content, nil
+	return u.String()
 }
 
-func ensureJPEG(data []byte) ([]byte, error) {
-	img, format, err := image.Decode(bytes.NewReader(data))
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode image: %v", err)
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
 	}
-	if format != "jpeg" {
-		var buf bytes.Buffer
-		if err := jpeg.Encode(&buf, img, nil); err != nil {
+	return fallback
+}
+
+// resolvePrompt picks the inline prompt or the contents of promptFile,
+// falling back to DefaultPrompt when neither is given. name is the flag name,
+// for the error message.
+func resolvePrompt(name, inline, promptFile string) (string, error) {
+	if inline != "" && promptFile != "" {
+		return "", fmt.Errorf("use either -%s or -%s-file, not both", name, name)
+	}
+	if promptFile != "" {
+		data, err := os.ReadFile(promptFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read prompt file: %v", err)
+		}
+		inline = string(data)
+	}
+	inline = strings.TrimSpace(inline)
+	if inline == "" {
+		return DefaultPrompt, nil
+	}
+	return inline, nil
+}
+
+// promptFor returns the prompt for an asset of the given type ("IMAGE" or "VIDEO").
+// Videos are described from their poster frame, so unless -video-prompt is
+// set, the model is told so.
+func promptFor(assetType string) string {
+	if assetType == "VIDEO" {
+		if VideoPrompt != "" {
+			return VideoPrompt
+		}
+		return "This image is a frame from a video. " + Prompt
+	}
+	return Prompt
+}
+
+func envInt(key string, fallback int) int {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Invalid %s=%q: %v", key, value, err)
+	}
+	return n
+}
+
+// orderDirection maps -order to the SQL sort direction for a."createdAt".
+func orderDirection() string {
+	if SortOrder == "oldest" {
+		return "ASC"
+	}
+	return "DESC"
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid %s=%q: %v", key, value, err)
+	}
+	return d
+}
+
+func envFloat(key string, fallback float64) float64 {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Fatalf("Invalid %s=%q: %v", key, value, err)
+	}
+	return f
+}
+
+func defaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: MaxRetries + 1,
+		BaseDelay:   2 * time.Second,
+		MaxDelay:    30 * time.Second,
+	}
+}
+
+// connectDB opens a connection pool to the Immich database and verifies it is reachable.
+// The pool transparently replaces broken connections, e.g. after a Postgres restart.
+func connectDB(ctx context.Context) (*pgxpool.Pool, error) {
+	cfg, err := pgxpool.ParseConfig(PostgresURL)
+	if err != nil {
+		return nil, err
+	}
+	cfg.MaxConns = int32(DBMaxConns)
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
+
+// runFile describes a local image with the configured models and prompt, for
+// trying out prompts and models without touching the library.
+func runFile(path string) {
+	imgBytes, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if n := frameCount(imgBytes); n > 1 {
+		textf("Animated image with %d frames, describing the first frame\n", n)
+		Logger.Info("multi-frame image, describing the first frame", "path", path, "frames", n)
+	}
+	imgBytes, err = ensureJPEG(imgBytes, &jpeg.Options{Quality: JPEGQuality}, MaxDimension)
+	if err != nil {
+		log.Fatalf("%s: %v", path, err)
+	}
+	b64Image := base64.StdEncoding.EncodeToString(imgBytes)
+
+	ctx := shutdownContext()
+	start := time.Now()
+	desc, model, err := describeChecked(ctx, newDescriber(), promptFor("IMAGE"), []string{b64Image}, Models, defaultRetryPolicy())
+	if err != nil {
+		log.Fatalf("Model error: %v", err)
+	}
+	desc, ok := cleanOutput(desc)
+	if !ok {
+		textf("[WARN] -output-regex did not match, showing the full output\n")
+	}
+	Logger.Info("file described", "path", path, "model", model, "duration_ms", time.Since(start).Milliseconds(), "description", desc)
+
+	textf("Model: %s (%.2fs)\n\n", model, time.Since(start).Seconds())
+	desc, keywords, ok := splitOutput(desc)
+	if !ok {
+		textf("[WARN] The model didn't return the requested JSON, parsed it as text\n")
+	}
+	if ExtractTags {
+		textf("Description: %s\nTags: %s\n", desc, strings.Join(keywords, ", "))
+		return
+	}
+	textf("%s\n", desc)
+}
+
+// resolveFilters looks up the album and owner given by name, printing what
+// they resolved to. It exits if either doesn't exist.
+func resolveFilters(ctx context.Context, conn *pgxpool.Pool) {
+	var err error
+	if Album != "" {
+		var name string
+		AlbumID, name, err = resolveAlbum(ctx, conn, Album)
+		if err != nil {
+			log.Fatal(err)
+		}
+		textf("Limiting to album: %s (%s)\n", name, AlbumID)
+		Logger.Info("album filter", "album", name, "album_id", AlbumID)
+	}
+	if NotInAlbum != "" {
+		var name string
+		NotInAlbumID, name, err = resolveAlbum(ctx, conn, NotInAlbum)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if Source == "api" {
+			if notInAlbumAssets, err = albumAssetIDs(ctx, NotInAlbumID); err != nil {
+				log.Fatalf("Failed to list album %s: %v", name, err)
+			}
+		}
+		textf("Skipping assets in album: %s (%s)\n", name, NotInAlbumID)
+		Logger.Info("album exclusion", "album", name, "album_id", NotInAlbumID, "add_described", AddToAlbum)
+	}
+	if Owner != "" {
+		var name string
+		OwnerID, name, err = resolveOwner(ctx, conn, Owner)
+		if err != nil {
+			log.Fatal(err)
+		}
+		textf("Limiting to owner: %s (%s)\n", name, OwnerID)
+		Logger.Info("owner filter", "owner", name, "owner_id", OwnerID)
+	}
+	if len(ExcludeTags) > 0 {
+		if err := checkExcludeTags(ctx, conn); err != nil {
+			log.Fatal(err)
+		}
+		textf("Excluding assets tagged: %s\n", strings.Join(ExcludeTags, ", "))
+		Logger.Info("tag filter", "exclude_tags", []string(ExcludeTags))
+	}
+}
+
+// runCount prints the number of assets the current filters would process,
+// without touching the model backend.
+func runCount() {
+	ctx := shutdownContext()
+	var conn *pgxpool.Pool
+	if Source == "db" {
+		var err error
+		conn, err = connectDB(ctx)
+		if err != nil {
+			log.Fatalf("Database connection failed: %v (URL: %s)", err, PostgresURLRedacted)
+		}
+		defer conn.Close()
+	}
+
+	resolveFilters(ctx, conn)
+	if conn != nil {
+		if err := target.prepare(ctx, conn, false); err != nil {
+			log.Fatalf("Invalid -target-column: %v", err)
+		}
+	}
+	counts, err := countPendingByType(ctx, conn)
+	if err != nil {
+		log.Fatalf("Count failed: %v", err)
+	}
+	Logger.Info("pending", "images", counts["IMAGE"], "videos", counts["VIDEO"])
+	if IncludeVideos {
+		textf("Pending: %d images, %d videos\n", counts["IMAGE"], counts["VIDEO"])
+	} else {
+		textf("Pending: %d images\n", counts["IMAGE"])
+	}
+}
+
+func runBenchmark() {
+	fmt.Println("--- BENCHMARK MODE ---")
+	models := BenchmarkModels
+	
+	ctx := shutdownContext()
+	conn, installed := preflight(ctx, nil)
+	defer conn.Close()
+
+	if installed != nil {
+		var available []string
+		for _, m := range models {
+			if modelInstalled(m, installed) {
+				available = append(available, m)
+			} else {
+				fmt.Printf("[WARN] Skipping %s: not installed in Ollama\n", m)
+			}
+		}
+		if len(available) == 0 {
+			log.Fatal("None of the benchmark models are installed")
+		}
+		models = available
+	}
+
+	// Get the sample images
+	f := &assetFilter{}
+	f.where(`a.type = 'IMAGE'`)
+	if len(ExcludeTags) > 0 {
+		f.where(excludeTagCond(f))
+	}
+	query := fmt.Sprintf(`
+		SELECT a.id
+		FROM asset a
+		%s
+		ORDER BY a."createdAt" %s
+		LIMIT %s
+	`, f.sql(), orderDirection(), f.arg(BenchmarkCount))
+	rows, err := conn.Query(ctx, query, f.args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	
+	var assetIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			log.Fatal(err)
+		}
+		assetIDs = append(assetIDs, id)
+	}
+	rows.Close()
+	describer := newDescriber()
+	results := &benchmarkResults{models: models}
+
+	// Downloads and conversions run concurrently up front, so the timed
+	// inference loop below never waits on I/O.
+	benchStart := time.Now()
+	fmt.Printf("Fetching %d images...\n", len(assetIDs))
+	images := prefetchBenchmarkImages(ctx, assetIDs)
+	prefetchTime := time.Since(benchStart)
+
+	for i, img := range images {
+		assetID := img.ID
+		fmt.Printf("\n[%d/%d] Image ID: %s\n", i+1, len(assetIDs), assetID)
+		
+		if ctx.Err() != nil {
+			break
+		}
+		if img.Err != nil {
+			fmt.Printf("Error %v\n", img.Err)
+			continue
+		}
+		b64Image := img.B64
+
+		for _, model := range models {
+			if ctx.Err() != nil {
+				break
+			}
+			fmt.Printf("  Testing %s ... ", model)
+			start := time.Now()
+			
+			// Call generate with specific model
+			desc, err := generateDescription(ctx, describer, Prompt, []string{b64Image}, model, defaultRetryPolicy())
+			duration := time.Since(start)
+
+			results.add(benchmarkResult{AssetID: assetID, Model: model, Duration: duration, DescLen: len([]rune(desc)), Err: err})
+
+			if err != nil {
+				fmt.Printf("FAILED (%v)\n", err)
+			} else {
+				fmt.Printf("DONE in %.2fs\n", duration.Seconds())
+				fmt.Printf("    -> Description: %s\n", desc)
+			}
+		}
+	}
+	fmt.Println("\n--- BENCHMARK COMPLETE ---")
+	fmt.Println()
+	results.printTable()
+	fmt.Printf("\nWall clock: %.2fs (image prefetch %.2fs, inference %.2fs)\n",
+		time.Since(benchStart).Seconds(), prefetchTime.Seconds(), results.inferenceTime().Seconds())
+
+	if BenchmarkCSV != "" {
+		if err := results.writeCSV(BenchmarkCSV); err != nil {
+			log.Fatalf("Failed to write %s: %v", BenchmarkCSV, err)
+		}
+		fmt.Printf("\nRaw results written to %s\n", BenchmarkCSV)
+	}
+}
+
+func runNormal(ctx context.Context) {
+	textf("Using model: %s\n", strings.Join(Models, " -> "))
+	if DryRun {
+		textf("DRY RUN — descriptions will be generated but not saved\n")
+	}
+	Logger.Info("run started", "models", Models, "download_workers", DownloadWorkers, "inference_workers", InferenceWorkers, "batch_size", BatchSize, "dry_run", DryRun, "watch", WatchMode)
+	runStart := time.Now()
+
+	proc := newProcessor(ctx)
+	if proc == nil {
+		textf("Aborted.\n")
+		return
+	}
+	if proc.conn != nil {
+		defer proc.conn.Close()
+	}
+	defer proc.cache.Close()
+
+	if len(AssetIDs) > 0 {
+		// Explicit IDs bypass the scan and are processed exactly once, overwriting any existing description.
+		textf("2. Processing %d requested assets...\n", len(AssetIDs))
+		var assets []assetRef
+		var err error
+		if ReprocessErrors != "" {
+			// Assets deleted since the report was written are dropped rather than failing the run.
+			var missing []string
+			assets, missing, err = findAssets(ctx, proc.conn, AssetIDs)
+			for _, id := range missing {
+				textf("   [SKIP] %s no longer exists\n", id)
+				Logger.Warn("asset not found", "asset_id", id)
+				proc.errors.resolve(id)
+			}
+		} else {
+			assets, err = lookupAssets(ctx, proc.conn, AssetIDs)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		// Excluded tags are a privacy setting, so they apply to explicit IDs too.
+		excluded, err := excludedByTag(ctx, proc.conn, assets)
+		if err != nil {
+			log.Fatal(err)
+		}
+		assets = slices.DeleteFunc(assets, func(a assetRef) bool {
+			if excluded[a.ID] {
+				textf("   [SKIP] %s has an excluded tag\n", a.ID)
+				Logger.Info("asset excluded by tag", "asset_id", a.ID)
+			}
+			return excluded[a.ID]
+		})
+		totalProcessed := 0
+		proc.throughput.reset(int64(len(assets)))
+		if Quiet {
+			startProgress(len(assets))
+		}
+		proc.runBatch(assets, &totalProcessed)
+		stopProgress()
+		textf("All done! Processed %d images in total.\n", totalProcessed)
+		proc.printSummary(totalProcessed, time.Since(runStart), ctx.Err() != nil)
+		return
+	}
+
+	totalProcessed, stoppedEarly := proc.scanAll(WatchMode)
+	proc.printSummary(totalProcessed, time.Since(runStart), stoppedEarly)
+}
+
+// confirmOverwrite shows how many existing descriptions an -overwrite run
+// would replace and asks for confirmation, unless -yes was given. It reports
+// whether to go ahead.
+func confirmOverwrite(ctx context.Context, conn *pgxpool.Pool) bool {
+	n, err := countOverwritten(ctx, conn)
+	if err != nil {
+		log.Fatalf("Failed to count existing descriptions: %v", err)
+	}
+	Logger.Info("overwrite", "existing_descriptions", n)
+	if n == 0 {
+		textf("No existing descriptions match the filters, nothing will be replaced\n")
+		return true
+	}
+	warning := fmt.Sprintf("-overwrite will replace %d existing descriptions, including ones written by hand.", n)
+	if AssumeYes {
+		textf("%s\n", warning)
+		return true
+	}
+	return confirm(warning)
+}
+
+// newProcessor checks the configuration, connects to the database and prepares
+// everything needed to process assets: album filter, model tracking, metrics,
+// checkpoint and model warm-up. It exits on configuration errors, and returns
+// nil if the user didn't confirm an -overwrite run.
+func newProcessor(ctx context.Context) *processor {
+	textf("1. Checking configuration...\n")
+	required := Models
+	if Embed {
+		required = append(slices.Clone(Models), EmbedModel)
+	}
+	conn, _ := preflight(ctx, required)
+	var err error
+
+	resolveFilters(ctx, conn)
+
+	if SinceLastRun {
+		cp, err := loadCheckpoint(CheckpointFile)
+		if err != nil {
+			log.Fatalf("Failed to load checkpoint %s: %v", CheckpointFile, err)
+		}
+		if CreatedAfter = cp.LastCreatedAt(); CreatedAfter.IsZero() {
+			textf("No previous complete run recorded, scanning all assets\n")
+		} else {
+			textf("Only scanning assets added since %s\n", CreatedAfter.Local().Format(time.DateTime))
+			Logger.Info("since last run", "created_after", CreatedAfter)
+		}
+	}
+
+	if OverwriteByModel != "" {
+		ok, err := metaTableExists(ctx, conn)
+		if err != nil {
+			log.Fatalf("Failed to check for %s table: %v", metaTable, err)
+		}
+		if !ok {
+			log.Fatalf("-overwrite-by-model needs model tracking data, but the %s table does not exist. Run with -track-model first.", metaTable)
+		}
+		textf("Re-describing images generated by: %s\n", OverwriteByModel)
+		Logger.Info("overwrite by model", "model", OverwriteByModel)
+	}
+
+	if !target.native() {
+		if err := target.prepare(ctx, conn, !DryRun); err != nil {
+			log.Fatalf("Invalid -target-column: %v", err)
+		}
+		textf("Writing descriptions to %s\n", target)
+		Logger.Info("description target", "target", target.String())
+	}
+
+	if Overwrite && OverwriteByModel == "" && len(AssetIDs) == 0 && !DryRun && !confirmOverwrite(ctx, conn) {
+		if conn != nil {
+			conn.Close()
+		}
+		return nil
+	}
+
+	if TrackModel && !DryRun {
+		if err := ensureMetaTable(ctx, conn); err != nil {
+			log.Fatalf("Failed to create %s table: %v", metaTable, err)
+		}
+	}
+
+	if Embed && !DryRun {
+		if err := ensureEmbeddingTable(ctx, conn); err != nil {
+			log.Fatalf("Failed to create %s table: %v", embeddingTable, err)
+		}
+	}
+
+	if MetricsAddr != "" {
+		if !metricsServing {
+			if err := startMetricsServer(MetricsAddr); err != nil {
+				log.Fatalf("Metrics server error: %v", err)
+			}
+			textf("Serving metrics on %s/metrics\n", MetricsAddr)
+			metricsServing = true
+		}
+		if n, err := countPending(ctx, conn); err == nil {
+			metricImagesPending.Set(float64(n))
+		}
+	}
+
+	if HealthAddr != "" {
+		if err := startHealthServer(HealthAddr, conn); err != nil {
+			log.Fatalf("Health server error: %v", err)
+		}
+		textf("Serving health checks on %s (/healthz, /readyz)\n", HealthAddr)
+	}
+
+	var checkpoint *checkpointStore
+	if !NoCheckpoint && !DryRun {
+		checkpoint, err = loadCheckpoint(CheckpointFile)
+		if err != nil {
+			log.Fatalf("Failed to load checkpoint %s: %v (use -no-checkpoint to ignore it)", CheckpointFile, err)
+		}
+		if n := checkpoint.Processed(); n > 0 {
+			textf("Resuming from %d processed (checkpoint: %s)\n", n, CheckpointFile)
+			Logger.Info("resuming", "processed", n, "checkpoint", CheckpointFile)
+		}
+	}
+
+	var cache *descriptionCache
+	var cached []cacheEntry
+	if CacheFile != "" && !DryRun {
+		cache, cached, err = openCache(CacheFile)
+		if err != nil {
+			log.Fatalf("Failed to open cache %s: %v", CacheFile, err)
+		}
+	}
+
+	proc := &processor{
+		ctx:         ctx,
+		conn:        conn,
+		describer:   newDescriber(),
+		policy:      defaultRetryPolicy(),
+		failures:    newFailureTracker(MaxFailuresPerAsset),
+		errors:      newFailureLog(reprocessFailures),
+		commitBatch: CommitBatch,
+		checkpoint:  checkpoint,
+		cache:       cache,
+		throughput:  newThroughput(),
+	}
+	if Source == "api" {
+		proc.pager = newAPIPager()
+	}
+	if Output == "json" {
+		proc.report = &runReport{}
+	}
+	if DedupeBursts {
+		proc.bursts = &burstIndex{}
+	}
+	if PHashReuseThreshold > 0 {
+		proc.reuse = &reuseIndex{}
+		// Re-describing means not reusing the existing descriptions, only
+		// those generated during this run.
+		if exists, err := metaTableExists(ctx, conn); err != nil {
+			log.Fatalf("Failed to check for %s table: %v", metaTable, err)
+		} else if exists && !Overwrite {
+			if proc.reuse, err = loadReuseIndex(ctx, conn); err != nil {
+				log.Fatalf("Failed to load perceptual hashes: %v", err)
+			}
+			textf("Reusing descriptions of similar images (%d known)\n", len(proc.reuse.entries))
+			Logger.Info("reuse index loaded", "hashes", len(proc.reuse.entries), "threshold", PHashReuseThreshold)
+		}
+	}
+	proc.replayCache(cached)
+	if o, ok := proc.describer.(*OllamaDescriber); ok && WarmUp {
+		textf("Loading model %s...\n", Models[0])
+		warmStart := time.Now()
+		if err := o.Preload(ctx, Models[0]); err != nil {
+			textf("   [WARN] Model warm-up failed: %v\n", err)
+			Logger.Warn("warm-up failed", "model", Models[0], "error", err.Error())
+		} else {
+			Logger.Info("model loaded", "model", Models[0], "duration_ms", time.Since(warmStart).Milliseconds())
+		}
+	}
+	return proc
+}
+
+// scanAll processes pending assets batch by batch until none are left or, in
+// watch mode, until shutdown. It returns the number of assets dispatched and
+// whether it was interrupted.
+func (p *processor) scanAll(watch bool) (int, bool) {
+	ctx, conn := p.ctx, p.conn
+	totalProcessed := 0
+	var cursor *scanCursor
+
+	if Quiet {
+		total := 0
+		if !watch {
+			if n, err := countPending(ctx, conn); err == nil {
+				total = int(n)
+			}
+			if Limit > 0 {
+				total = min(total, Limit)
+			}
+		}
+		startProgress(total)
+		defer stopProgress()
+	}
+
+	var newest time.Time // newest asset seen, for -since-last-run
+	idleScans := 0       // consecutive watch cycles without work, for -adaptive-interval
+	saved := 0           // assets described, for -limit
+	newCycle := true
+	for {
+		if ctx.Err() != nil {
+			textf("Stopped early. Processed %d images.\n", totalProcessed)
+			return totalProcessed, true
+		}
+		if newCycle {
+			newCycle = false
+			if p.pager != nil {
+				p.pager.reset()
+			}
+			p.startCycle()
+		}
+
+		batchSize := BatchSize
+		if Limit > 0 {
+			// Don't fetch more than the limit leaves, so nothing is dispatched beyond it.
+			batchSize = min(batchSize, Limit-saved)
+		}
+		textf("2. Scanning for images (batch of %d)...\n", batchSize)
+		Logger.Info("scan started", "batch_size", batchSize)
+		var assets []assetRef
+		var last *scanCursor
+		var err error
+		if p.pager != nil {
+			assets, err = p.pager.next(ctx, p.excluded(), batchSize)
+		} else {
+			assets, last, err = scanAssets(ctx, conn, p.excluded(), cursor, batchSize)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			if watch && p.pager != nil {
+				// Like a database restart, an Immich restart shouldn't end the daemon.
+				textf("[API] Listing assets failed (%v), retrying in %v\n", err, WatchInterval)
+				Logger.Warn("asset listing failed, retrying", "delay", WatchInterval.String(), "error", err.Error())
+				sleepCtx(ctx, WatchInterval)
+				continue
+			}
+			if watch && dbTransient(err) {
+				// The daemon should survive database restarts: wait for it to come back and scan again.
+				if err := waitForDB(ctx, conn); err != nil && ctx.Err() == nil {
+					log.Fatalf("Database unreachable, giving up after %d reconnect attempts: %v", DBReconnectAttempts, err)
+				}
+				continue
+			}
+			log.Fatal(err)
+		}
+		if Overwrite && last != nil {
+			cursor = last
+		}
+		p.mu.Lock()
+		if p.created == nil {
+			p.created = make(map[string]time.Time)
+		}
+		for _, a := range assets {
+			if a.CreatedAt.After(newest) {
+				newest = a.CreatedAt
+			}
+			p.created[a.ID] = a.CreatedAt
+		}
+		p.mu.Unlock()
+
+		if len(assets) == 0 {
+			if watch {
+				if totalProcessed > 0 {
+					idleScans = 0
+					textf("All caught up! Processed %d images.\n", totalProcessed)
+					Logger.Info("caught up", "processed", totalProcessed)
+					p.notify(webhookPayload{
+						Event:           "cycle_complete",
+						Processed:       totalProcessed,
+						Failed:          p.errors.failedSince(p.cycleStart),
+						DurationSeconds: time.Since(p.cycleStart).Seconds(),
+					})
+					totalProcessed = 0
+				} else {
+					idleScans++
+				}
+				p.recordScan(newest)
+				interval := watchInterval(idleScans)
+				textf("Sleeping for %v... (Ctrl+C to stop)\n", interval)
+				Logger.Debug("sleeping", "interval", interval.String(), "idle_scans", idleScans)
+				sleepCtx(ctx, interval)
+				newCycle = true
+				if conn == nil {
+					continue
+				}
+				// Connections may have died while idle.
+				if err := waitForDB(ctx, conn); err != nil && ctx.Err() == nil {
+					log.Fatalf("Database unreachable, giving up after %d reconnect attempts: %v", DBReconnectAttempts, err)
+				}
+				continue
+			}
+
+			if totalProcessed == 0 {
+				textf("No images found to process.\n")
+			} else {
+				textf("All done! Processed %d images in total.\n", totalProcessed)
+			}
+			p.recordScan(newest)
+			return totalProcessed, false
+		}
+
+		batchSuccess := p.runBatch(assets, &totalProcessed)
+		saved += batchSuccess
+		if Limit > 0 && saved >= Limit {
+			textf("Reached -limit of %d images. Processed %d images in total.\n", Limit, totalProcessed)
+			Logger.Info("limit reached", "limit", Limit, "processed", totalProcessed)
+			return totalProcessed, false
+		}
+
+		// If we found images but processed none (e.g. all 404), sleep to avoid hammering
+		if len(assets) > 0 && batchSuccess == 0 && ctx.Err() == nil {
+			textf("Batch failed (waiting for thumbnails). Sleeping 30s...\n")
+			Logger.Warn("batch failed, backing off", "sleep", "30s")
+			sleepCtx(ctx, 30*time.Second)
+		}
+	}
+}
+
+// watchInterval returns the time to sleep after a watch cycle. With
+// -adaptive-interval it doubles for every further cycle without work, up to
+// -max-interval, and is back to -interval as soon as there is work.
+func watchInterval(idleScans int) time.Duration {
+	d := WatchInterval
+	if !AdaptiveInterval {
+		return d
+	}
+	for i := 1; i < idleScans && d < MaxWatchInterval; i++ {
+		d *= 2
+	}
+	return min(d, MaxWatchInterval)
+}
+
+// recordScan stores the newest asset seen by a complete scan as the starting
+// point for -since-last-run. Scans narrowed by album, owner, date or favorites
+// don't count, since older assets outside the filter may still be pending.
+func (p *processor) recordScan(newest time.Time) {
+	if Album != "" || Owner != "" || !DateFrom.IsZero() || !DateTo.IsZero() || OnlyFavorites || OverwriteByModel != "" {
+		return
+	}
+	// Assets that failed must be scanned again by the next -since-last-run, so
+	// the mark stays just before the oldest of them.
+	failed := p.errors.list()
+	p.mu.Lock()
+	created := make(map[string]time.Time, len(failed))
+	for _, f := range failed {
+		t, ok := p.created[f.AssetID]
+		if !ok {
+			continue
+		}
+		created[f.AssetID] = t
+		if !t.After(newest) {
+			newest = t.Add(-time.Microsecond)
+		}
+	}
+	p.created = created
+	p.mu.Unlock()
+	if err := p.checkpoint.RecordScan(newest); err != nil {
+		textf("   [WARN] Checkpoint write error: %v\n", err)
+		Logger.Warn("checkpoint write failed", "path", CheckpointFile, "error", err.Error())
+	}
+}
+
+// startCycle notes the start of a scan cycle and updates the scan metrics, including a
+// fresh pending count so the backlog trend is visible in watch mode.
+func (p *processor) startCycle() {
+	p.cycleStart = time.Now()
+	if MetricsAddr != "" {
+		metricScanCycles.Inc()
+		metricLastScan.SetToCurrentTime()
+	} else if ProgressInterval <= 0 || Quiet {
+		return
+	}
+	n, err := countPending(p.ctx, p.conn)
+	if err != nil {
+		Logger.Warn("pending count failed", "error", err.Error())
+		p.throughput.reset(-1)
+		return
+	}
+	metricImagesPending.Set(float64(n))
+	p.throughput.reset(n)
+}
+
+// runBatch feeds assets to the worker pool and waits for them to finish.
+// total is the running count of dispatched assets; it returns the number saved.
+// No new assets are dispatched once shutdown was requested.
+func (p *processor) runBatch(assets []assetRef, total *int) int {
+	jobs := make(chan assetJob)
+	// Downloads run ahead of inference, but only by a few images.
+	prepared := make(chan preparedImage, InferenceWorkers)
+	var success atomic.Int64
+
+	var downloads sync.WaitGroup
+	for range DownloadWorkers {
+		downloads.Go(func() {
+			for job := range jobs {
+				prepared <- p.fetch(job)
+			}
+		})
+	}
+	var inference sync.WaitGroup
+	for range InferenceWorkers {
+		inference.Go(func() {
+			for img := range prepared {
+				if p.ctx.Err() != nil {
+					// Not started yet: leave it for the next run.
+					continue
+				}
+				success.Add(int64(p.handle(img)))
+			}
+		})
+	}
+dispatch:
+	for i, asset := range assets {
+		job := assetJob{ID: asset.ID, Type: asset.Type, CreatedAt: asset.CreatedAt, Count: i + 1, Total: *total + 1}
+		select {
+		case jobs <- job:
+			*total++
+		case <-p.ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	downloads.Wait()
+	close(prepared)
+	inference.Wait()
+	// Also runs when stopping early, so nothing described is lost.
+	success.Add(int64(p.flush()))
+	return int(success.Load())
+}
+
+// assetJob is a unit of work handed to a worker, numbered for the status line.
+type assetJob struct {
+	ID        string
+	Type      string
+	CreatedAt time.Time // zero outside of scans
+	Count     int
+	Total     int
+}
+
+// processor holds the state shared by the workers in runNormal.
+type processor struct {
+	ctx        context.Context
+	conn       *pgxpool.Pool // nil with -source api
+	pager      *apiPager     // lists the assets with -source api
+	describer  Describer
+	policy     RetryPolicy
+	failures   *failureTracker
+	errors     *failureLog
+	checkpoint *checkpointStore
+	cache      *descriptionCache
+	throughput *throughput
+	bursts     *burstIndex // with -dedupe-bursts
+	reuse      *reuseIndex // with -phash-reuse-threshold
+	report     *runReport  // with -output json
+
+	burstCopies atomic.Int64
+	reused      atomic.Int64
+
+	// commitBatch is the number of descriptions saved per transaction; with
+	// more than 1, saving is deferred to flush.
+	commitBatch int
+
+	cycleStart time.Time // start of the current scan cycle, for the webhook
+
+	mu        sync.Mutex
+	previewed []string             // dry-run only
+	created   map[string]time.Time // creation time of scanned assets, for recordScan
+	pending   []queuedSave
+}
+
+// handle describes a downloaded asset and prints a single status line for it.
+// It returns the number of descriptions saved: with -commit-batch, 0 while
+// the asset waits in the batch, and the whole batch once it is committed.
+func (p *processor) handle(img preparedImage) int {
+	job := img.job
+	prefix := fmt.Sprintf("[%d|Total:%d] Processing %s", job.Count, job.Total, job.ID)
+	if img.unchanged {
+		progress.add(true)
+		if !Quiet {
+			textf("%s ... Unchanged, skipped\n", prefix)
+		}
+		Logger.Info("asset unchanged", "asset_id", job.ID)
+		p.report.add(assetOutcome{AssetID: job.ID, Status: "unchanged", DurationMs: time.Since(img.start).Milliseconds()})
+		p.throughput.add()
+		// Not a failure, so it doesn't count towards the backoff for failed batches.
+		return 1
+	}
+	var res assetResult
+	var err *stepError
+	if p.bursts != nil && img.hashed && !job.CreatedAt.IsZero() {
+		res, err = p.describeBurst(img)
+	} else {
+		res, err = p.describe(img)
+	}
+	progress.add(err == nil)
+	if err != nil {
+		p.skip(prefix, job.ID, img.start, err)
+		return 0
+	}
+	if p.commitBatch > 1 && !DryRun {
+		// Saved (and reported) when the batch is committed.
+		p.throughput.add()
+		return p.queue(prefix, res)
+	}
+	p.errors.resolve(job.ID)
+	status := "Done!"
+	if DryRun {
+		status = "Would save"
+	}
+	p.printResult(prefix, status, res)
+	p.throughput.add()
+	return 1
+}
+
+// queuedSave is a description waiting for the next batch commit.
+type queuedSave struct {
+	prefix string
+	res    assetResult
+}
+
+// queue adds a described asset to the pending batch and commits the batch
+// once it holds commitBatch assets. It returns the number saved by the commit.
+func (p *processor) queue(prefix string, res assetResult) int {
+	p.mu.Lock()
+	p.pending = append(p.pending, queuedSave{prefix, res})
+	full := len(p.pending) >= p.commitBatch
+	p.mu.Unlock()
+	if !full {
+		return 0
+	}
+	return p.flush()
+}
+
+// flush saves all queued descriptions in one transaction. If the transaction
+// is rejected, each asset is saved on its own so one bad row doesn't fail the rest.
+// It returns the number of descriptions saved.
+func (p *processor) flush() int {
+	p.mu.Lock()
+	items := p.pending
+	p.pending = nil
+	p.mu.Unlock()
+	if len(items) == 0 {
+		return 0
+	}
+
+	ctx := context.WithoutCancel(p.ctx)
+	writes := make([]descriptionWrite, len(items))
+	for i, it := range items {
+		writes[i] = it.res.write()
+	}
+	batchErr := saveDescriptions(ctx, p.conn, writes)
+	if batchErr != nil {
+		Logger.Warn("batch commit failed", "assets", len(items), "error", batchErr.Error())
+	} else {
+		Logger.Debug("batch committed", "assets", len(items))
+	}
+
+	saved := 0
+	for _, it := range items {
+		if batchErr != nil {
+			err := batchErr
+			// Retrying row by row only helps if the database is reachable.
+			if !dbTransient(batchErr) {
+				err = saveDescription(ctx, p.conn, it.res.write())
+			}
+			if err != nil {
+				p.skip(it.prefix, it.res.ID, it.res.Start, &stepError{"db", fmt.Sprintf("[ERR] DB Save error: %v", err), err})
+				continue
+			}
+		}
+		p.afterSave(ctx, &it.res)
+		p.errors.resolve(it.res.ID)
+		p.printResult(it.prefix, "Done!", it.res)
+		saved++
+	}
+	return saved
+}
+
+// stepError is a failed pipeline step. reason is the step that failed
+// (download, conversion, ollama or db) and message the status line shown for it.
+type stepError struct {
+	reason  string
+	message string
+	err     error
+}
+
+func (e *stepError) Error() string { return e.err.Error() }
+func (e *stepError) Unwrap() error { return e.err }
+
+// process downloads, describes and saves one asset.
+func (p *processor) process(job assetJob) (assetResult, *stepError) {
+	return p.describe(p.fetch(job))
+}
+
+// preparedImage is an asset whose image has been downloaded and converted, or
+// the error that prevented it.
+type preparedImage struct {
+	job   assetJob
+	b64   string
+	hash  string
+	start time.Time
+	err   *stepError
+	// unchanged is set with -skip-unchanged when the thumbnail matches the
+	// one the current description was generated from; b64 is then empty.
+	unchanged bool
+	// phash is the perceptual hash of the image, if hashed is set
+	// (-dedupe-bursts, -phash-reuse-threshold).
+	phash  uint64
+	hashed bool
+	// stack holds the other shots of the asset's stack (-describe-stacks).
+	stack []string
+}
+
+// fetch downloads the asset's image and converts it for the model.
+func (p *processor) fetch(job assetJob) preparedImage {
+	ctx := context.WithoutCancel(p.ctx)
+	img := preparedImage{job: job, start: time.Now()}
+
+	imgBytes, err := downloadThumbnail(ctx, job.ID)
+	if err != nil {
+		if errors.Is(err, errNoThumbnail) {
+			img.err = &stepError{"download", "[SKIP] No thumbnail (404), skipping for the rest of this run", err}
+		} else {
+			img.err = &stepError{"download", fmt.Sprintf("[SKIP] Download error: %v", err), err}
+		}
+		return img
+	}
+
+	sum := sha256.Sum256(imgBytes)
+	img.hash = hex.EncodeToString(sum[:])
+	if SkipUnchanged {
+		stored, err := storedHash(ctx, p.conn, job.ID)
+		if err != nil {
+			Logger.Warn("thumbnail hash lookup failed", "asset_id", job.ID, "error", err.Error())
+		} else if stored == img.hash {
+			img.unchanged = true
+			return img
+		}
+	}
+
+	if n := frameCount(imgBytes); n > 1 {
+		Logger.Info("multi-frame image, describing the first frame", "asset_id", job.ID, "frames", n)
+	}
+	if !keepWebP(imgBytes, MaxDimension) {
+		imgBytes, err = ensureJPEG(imgBytes, &jpeg.Options{Quality: JPEGQuality}, MaxDimension)
+	}
+	if err != nil {
+		img.err = &stepError{"conversion", fmt.Sprintf("[SKIP] Image conversion error: %v", err), err}
+		return img
+	}
+	if (DedupeBursts && !job.CreatedAt.IsZero()) || PHashReuseThreshold > 0 {
+		// Without a hash the asset is simply described on its own.
+		if img.phash, err = perceptualHash(imgBytes); err != nil {
+			Logger.Warn("perceptual hash failed", "asset_id", job.ID, "error", err.Error())
+		} else {
+			img.hashed = true
+		}
+	}
+
+	img.b64 = base64.StdEncoding.EncodeToString(imgBytes)
+	if DescribeStacks && StackImages > 1 && job.Type == "IMAGE" {
+		img.stack = p.fetchStackImages(ctx, job.ID)
+	}
+	return img
+}
+
+// describe generates and saves the description for a fetched image. An asset
+// that has started is always finished, even if shutdown was requested in the
+// meantime.
+func (p *processor) describe(img preparedImage) (assetResult, *stepError) {
+	if img.err != nil {
+		return assetResult{}, img.err
+	}
+	if p.reuse != nil && img.hashed {
+		if res, ok := p.reuseSimilar(img); ok {
+			res, err := p.save(res)
+			if err == nil {
+				p.reused.Add(1)
+			}
+			return res, err
+		}
+	}
+	res, err := p.generate(img)
+	if err != nil {
+		return assetResult{}, err
+	}
+	return p.save(res)
+}
+
+// describeBurst is describe for -dedupe-bursts: if an earlier shot of the same
+// burst was described, its description is copied instead.
+func (p *processor) describeBurst(img preparedImage) (assetResult, *stepError) {
+	job := img.job
+	for {
+		e, own := p.bursts.claim(job.ID, job.CreatedAt, img.phash)
+		if own {
+			res, err := p.describe(img)
+			if err != nil {
+				p.bursts.finish(e, nil)
+			} else {
+				p.bursts.finish(e, &res)
+			}
+			return res, err
+		}
+		<-e.done
+		if e.res == nil {
+			continue // the representative failed, look again
+		}
+		p.bursts.follow(e, job.CreatedAt, img.phash)
+		res := *e.res
+		res.ID, res.Hash, res.PHash, res.Start, res.CopiedFrom = job.ID, img.hash, img.storedPHash(), img.start, e.assetID
+		Logger.Info("burst description copied", "asset_id", job.ID, "from", e.assetID)
+		res, err := p.save(res)
+		if err == nil {
+			p.burstCopies.Add(1)
+		}
+		return res, err
+	}
+}
+
+// reuseSimilar returns the description of the most similar described asset
+// for img, if one is within -phash-reuse-threshold.
+func (p *processor) reuseSimilar(img preparedImage) (assetResult, bool) {
+	job := img.job
+	e, dist, ok := p.reuse.nearest(job.ID, img.phash)
+	if !ok {
+		return assetResult{}, false
+	}
+	var res assetResult
+	if e.res != nil {
+		res = *e.res
+	} else {
+		desc, model, err := storedDescription(context.WithoutCancel(p.ctx), p.conn, e.assetID)
+		if err != nil {
+			Logger.Warn("reused description lookup failed", "asset_id", job.ID, "from", e.assetID, "error", err.Error())
+			return assetResult{}, false
+		}
+		if desc == "" {
+			return assetResult{}, false // cleared since it was described
+		}
+		res = assetResult{Desc: desc, Model: model}
+	}
+	res.ID, res.Hash, res.PHash, res.Start, res.CopiedFrom = job.ID, img.hash, img.storedPHash(), img.start, e.assetID
+	Logger.Info("description reused", "asset_id", job.ID, "from", e.assetID, "distance", dist)
+	return res, true
+}
+
+// storedPHash returns the perceptual hash in the form stored in the database,
+// or nil if the image wasn't hashed.
+func (img preparedImage) storedPHash() *int64 {
+	if !img.hashed {
+		return nil
+	}
+	h := int64(img.phash)
+	return &h
+}
+
+// generate runs the model on a fetched image and post-processes its output.
+func (p *processor) generate(img preparedImage) (assetResult, *stepError) {
+	ctx := context.WithoutCancel(p.ctx)
+	job, start, b64Image := img.job, img.start, img.b64
+
+	prompt := promptFor(job.Type)
+	if UseExifContext {
+		// Missing context only makes the description less specific, so it is not an error.
+		extra, err := exifContext(ctx, p.conn, job.ID)
+		if err != nil {
+			textf("   [WARN] EXIF context unavailable for %s: %v\n", job.ID, err)
+			Logger.Warn("exif context failed", "asset_id", job.ID, "error", err.Error())
+		}
+		prompt = extra + prompt
+	}
+
+	images := []string{b64Image}
+	if len(img.stack) > 0 {
+		images = append(images, img.stack...)
+		prompt = stackPrompt(len(images)) + prompt
+		Logger.Info("describing stack", "asset_id", job.ID, "images", len(images))
+	}
+
+	desc, model, err := describeChecked(ctx, p.describer, prompt, images, Models, p.policy)
+	if errors.Is(err, errEmptyResponse) {
+		return assetResult{}, emptyResponse(model)
+	}
+	if err != nil {
+		return assetResult{}, &stepError{"ollama", fmt.Sprintf("[FAIL] Ollama error: %v", err), err}
+	}
+
+	if clean, changed := sanitizeText(desc); changed {
+		textf("   [WARN] %s: removed %d bytes of invalid UTF-8 or control characters from the model output\n", job.ID, len(desc)-len(clean))
+		Logger.Warn("description sanitized", "asset_id", job.ID, "model", model, "removed_bytes", len(desc)-len(clean))
+		desc = clean
+	}
+
+	desc, ok := cleanOutput(desc)
+	if !ok {
+		textf("   [WARN] %s: -output-regex did not match, saving the full output\n", job.ID)
+		Logger.Warn("output regex did not match", "asset_id", job.ID, "model", model)
+	}
+
+	res := assetResult{ID: job.ID, Model: model, Hash: img.hash, PHash: img.storedPHash(), Start: start}
+	if res.Desc, res.Keywords, ok = splitOutput(desc); !ok {
+		textf("   [WARN] %s: the model didn't return the requested JSON, parsing it as text\n", job.ID)
+		Logger.Warn("structured output not parsed", "asset_id", job.ID, "model", model)
+	}
+	if strings.TrimSpace(res.Desc) == "" {
+		// Saving it would mark the asset as done without describing it.
+		return assetResult{}, emptyResponse(model)
+	}
+	if n := len([]rune(res.Desc)); n < MinChars {
+		if VerboseMode {
+			textf("   [SHORT] %s: %q\n", job.ID, res.Desc)
+		}
+		Logger.Debug("short description rejected", "asset_id", job.ID, "model", model, "description", res.Desc)
+		err := fmt.Errorf("description too short: %d chars, -min-chars is %d", n, MinChars)
+		return assetResult{}, &stepError{"too short", fmt.Sprintf("[FAIL] %s returned only %d chars (-min-chars %d)", model, n, MinChars), err}
+	}
+
+	if !wantsEnglish() && looksEnglish(res.Desc) {
+		textf("   [WARN] %s: description looks like English, not %s\n", job.ID, Language)
+		Logger.Warn("unexpected language", "asset_id", job.ID, "language", Language, "model", model)
+	}
+	if short, ok := truncateAtWord(res.Desc, MaxChars); ok {
+		if VerboseMode {
+			textf("   [TRUNCATE] %s: %d chars cut to %d\n", job.ID, len([]rune(res.Desc)), MaxChars)
+		}
+		Logger.Info("description truncated", "asset_id", job.ID, "model", model, "length", len([]rune(res.Desc)), "max_chars", MaxChars)
+		res.Desc = short
+	}
+	return res, nil
+}
+
+// emptyResponse is the failure for a model output with no description in it.
+func emptyResponse(model string) *stepError {
+	return &stepError{"empty response", fmt.Sprintf("[FAIL] %s returned an empty description", model), errEmptyResponse}
+}
+
+// save writes a generated description, or with -commit-batch queues it.
+func (p *processor) save(res assetResult) (assetResult, *stepError) {
+	ctx := context.WithoutCancel(p.ctx)
+	if DryRun {
+		p.mu.Lock()
+		p.previewed = append(p.previewed, res.ID)
+		p.mu.Unlock()
+		p.remember(res)
+		return res, nil
+	}
+
+	// Keep the GPU work even if saving fails below.
+	if err := p.cache.add(res); err != nil {
+		Logger.Warn("cache write failed", "path", CacheFile, "error", err.Error())
+	}
+
+	if p.commitBatch > 1 {
+		return res, nil
+	}
+
+	if err := saveDescription(ctx, p.conn, res.write()); err != nil {
+		return assetResult{}, &stepError{"db", fmt.Sprintf("[ERR] DB Save error: %v", err), err}
+	}
+	p.afterSave(ctx, &res)
+	return res, nil
+}
+
+// afterSave does the bookkeeping for a saved description: metrics, checkpoint
+// and, with -extract-tags, tagging.
+func (p *processor) afterSave(ctx context.Context, res *assetResult) {
+	metricImagesProcessed.Inc()
+	metricImagesPending.Dec()
+	if err := p.cache.saved(res.ID); err != nil {
+		Logger.Warn("cache write failed", "path", CacheFile, "error", err.Error())
+	}
+	p.remember(*res)
+	if err := p.checkpoint.Record(res.ID); err != nil {
+		textf("   [WARN] Checkpoint write error: %v\n", err)
+		Logger.Warn("checkpoint write failed", "path", CheckpointFile, "error", err.Error())
+	}
+
+	if ExtractTags {
+		// The description is already saved, so a tagging failure is reported but not retried.
+		if err := applyTags(ctx, res.ID, res.Keywords); err != nil {
+			textf("   [WARN] Tagging error for %s: %v\n", res.ID, err)
+			Logger.Warn("tagging failed", "asset_id", res.ID, "error", err.Error())
+			res.Keywords = nil
+		}
+	}
+
+	if AddToAlbum {
+		if err := addToAlbum(ctx, res.ID); err != nil {
+			textf("   [WARN] Adding %s to album %s failed: %v\n", res.ID, NotInAlbum, err)
+			Logger.Warn("adding to album failed", "asset_id", res.ID, "album_id", NotInAlbumID, "error", err.Error())
+		}
+	}
+
+	if Embed {
+		if err := saveEmbedding(ctx, p.conn, res.ID, res.Desc); err != nil {
+			textf("   [WARN] Embedding error for %s: %v\n", res.ID, err)
+			Logger.Warn("embedding failed", "asset_id", res.ID, "model", EmbedModel, "error", err.Error())
+		}
+	}
+
+	if Sidecar != "" {
+		if err := writeSidecar(ctx, p.conn, *res); err != nil {
+			textf("   [WARN] Sidecar error for %s: %v\n", res.ID, err)
+			Logger.Warn("sidecar write failed", "asset_id", res.ID, "error", err.Error())
+		}
+	}
+}
+
+// remember adds a description to the reuse index, so later similar images can
+// reuse it.
+func (p *processor) remember(res assetResult) {
+	if p.reuse != nil && res.PHash != nil {
+		p.reuse.add(res, uint64(*res.PHash))
+	}
+}
+
+// assetResult describes a successfully described asset, for reporting.
+type assetResult struct {
+	ID       string
+	Desc     string
+	Keywords []string
+	Model    string // the model that produced Desc (may be a fallback)
+	Hash     string // SHA-256 of the downloaded thumbnail
+	PHash    *int64 // perceptual hash of the thumbnail, if computed
+	Start    time.Time
+	// CopiedFrom is the asset whose description was reused instead of
+	// generating one (-dedupe-bursts, -phash-reuse-threshold).
+	CopiedFrom string
+}
+
+func (r assetResult) write() descriptionWrite {
+	return descriptionWrite{AssetID: r.ID, Desc: r.Desc, Model: r.Model, Hash: r.Hash, PHash: r.PHash}
+}
+
+// skip reports a failed pipeline step for an asset and records the failure.
+func (p *processor) skip(prefix, assetID string, start time.Time, err *stepError) {
+	if !Quiet {
+		textf("%s\n   %s\n", prefix, err.message)
+	}
+	Logger.Warn("asset skipped", "asset_id", assetID, "reason", err.reason, "error", err.Error())
+	p.report.add(assetOutcome{AssetID: assetID, Status: "failed", Reason: err.reason, Error: err.Error(), DurationMs: time.Since(start).Milliseconds()})
+	metricImagesFailed.WithLabelValues(err.reason).Inc()
+	p.errors.add(assetID, err.reason, err)
+	if errors.Is(err, errNoThumbnail) {
+		// Retrying can't help, so don't wait for -max-failures-per-asset.
+		p.failures.giveUp(assetID)
+		return
+	}
+	p.fail(assetID)
+}
+
+// printResult prints the status line for a finished asset, with the full
+// description and tags in verbose mode.
+func (p *processor) printResult(prefix, status string, r assetResult) {
+	Logger.Info("asset processed",
+		"asset_id", r.ID,
+		"duration_ms", time.Since(r.Start).Milliseconds(),
+		"description_length", len(r.Desc),
+		"tags", len(r.Keywords),
+		"model", r.Model,
+		"copied_from", r.CopiedFrom,
+		"dry_run", DryRun,
+	)
+	Logger.Debug("description", "asset_id", r.ID, "description", r.Desc, "keywords", r.Keywords)
+	outcome := "saved"
+	if DryRun {
+		outcome = "would_save"
+	}
+	p.report.add(assetOutcome{
+		AssetID:    r.ID,
+		Status:     outcome,
+		Length:     len(r.Desc),
+		Model:      r.Model,
+		CopiedFrom: r.CopiedFrom,
+		DurationMs: time.Since(r.Start).Milliseconds(),
+	})
+
+	details := fmt.Sprintf("%d chars", len(r.Desc))
+	if ExtractTags {
+		details += fmt.Sprintf(", %d tags", len(r.Keywords))
+	}
+	if r.Model != Models[0] {
+		details += ", via " + r.Model
+	}
+	if r.CopiedFrom != "" {
+		details += ", copied from " + r.CopiedFrom
+	}
+	line := fmt.Sprintf("%s ... %s (%s)\n", prefix, status, details)
+	if VerboseMode {
+		line += fmt.Sprintf("Description: %s\n", r.Desc)
+		if len(r.Keywords) > 0 {
+			line += fmt.Sprintf("Tags: %s\n", strings.Join(r.Keywords, ", "))
+		}
+	}
+	if !Quiet {
+		textf("%s", line)
+	}
+}
+
+// printSummary lists the assets that were given up on during this run and
+// emits the final "run complete" event.
+func (p *processor) printSummary(processed int, elapsed time.Duration, stoppedEarly bool) {
+	p.errors.printGrouped()
+	skipped := p.failures.skipped()
+	missing := p.failures.noThumbnail()
+	var repeated []string
+	for _, id := range skipped {
+		if !contains(missing, id) {
+			repeated = append(repeated, id)
+		}
+	}
+	if len(repeated) > 0 {
+		textf("Gave up on %d images after repeated failures:\n", len(repeated))
+		for _, id := range repeated {
+			textf("   - %s\n", id)
+		}
+	}
+	if len(missing) > 0 {
+		textf("Skipped %d images without a thumbnail (regenerate them in Immich under Administration > Jobs):\n", len(missing))
+		for _, id := range missing {
+			textf("   - %s\n", id)
+		}
+	}
+	if ErrorReport != "" {
+		if err := p.errors.write(ErrorReport); err != nil {
+			textf("[WARN] Failed to write error report: %v\n", err)
+			Logger.Warn("error report write failed", "path", ErrorReport, "error", err.Error())
+		} else {
+			textf("Error report written to %s\n", ErrorReport)
+		}
+	}
+	if n := p.burstCopies.Load(); n > 0 {
+		textf("Reused descriptions for %d burst shots\n", n)
+	}
+	if n := p.reused.Load(); n > 0 {
+		textf("Reused descriptions for %d similar images\n", n)
+	}
+	if DryRun {
+		textf("DRY RUN — no changes written\n")
+	}
+	Logger.Info("run complete",
+		"processed", processed,
+		"gave_up", repeated,
+		"no_thumbnail", missing,
+		"failed", len(p.errors.list()),
+		"burst_copies", p.burstCopies.Load(),
+		"reused", p.reused.Load(),
+		"duration_ms", elapsed.Milliseconds(),
+		"models", Models,
+		"dry_run", DryRun,
+		"stopped_early", stoppedEarly,
+	)
+	if p.report != nil {
+		err := p.report.write(runSummary{
+			Processed:       processed,
+			Failed:          len(p.errors.list()),
+			GaveUp:          repeated,
+			NoThumbnail:     missing,
+			BurstCopies:     p.burstCopies.Load(),
+			Reused:          p.reused.Load(),
+			Models:          Models,
+			DryRun:          DryRun,
+			StoppedEarly:    stoppedEarly,
+			DurationSeconds: elapsed.Seconds(),
+		})
+		if err != nil {
+			Logger.Warn("report write failed", "error", err.Error())
+		}
+	}
+	p.notify(webhookPayload{
+		Event:           "run_complete",
+		Processed:       processed,
+		Failed:          len(p.errors.list()),
+		DurationSeconds: elapsed.Seconds(),
+		StoppedEarly:    stoppedEarly,
+	})
+}
+
+// notify sends the webhook notifications, with the remaining backlog.
+func (p *processor) notify(payload webhookPayload) {
+	if !notificationsEnabled() {
+		return
+	}
+	ctx := context.WithoutCancel(p.ctx)
+	if n, err := countPending(ctx, p.conn); err == nil {
+		payload.Pending = &n
+	} else {
+		Logger.Warn("pending count failed", "error", err.Error())
+	}
+	sendWebhook(ctx, payload)
+}
+
+// excluded returns the asset IDs the scan query must not return again: those
+// given up on, and in dry-run mode those already previewed (their description
+// is still empty in the DB, so they would otherwise be selected forever).
+func (p *processor) excluded() []string {
+	ids := p.failures.skipped()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append(ids, p.previewed...)
+}
+
+// fail records a failed attempt and tells the user when the asset is given up on.
+func (p *processor) fail(assetID string) {
+	if p.failures.record(assetID) {
+		textf("   [GIVE UP] %s failed %d times, skipping for the rest of this run\n", assetID, MaxFailuresPerAsset)
+		Logger.Warn("asset given up", "asset_id", assetID, "failures", MaxFailuresPerAsset)
+	}
+}
+
+// confirm prints warning and asks the user to type "yes" on stdin.
+func confirm(warning string) bool {
+	out := os.Stdout
+	if Output == "json" {
+		// Keep stdout for the report.
+		out = os.Stderr
+	}
+	fmt.Fprintf(out, "%s\nType 'yes' to continue: ", warning)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(strings.TrimSpace(answer), "yes")
+}
+
+// shutdownContext returns a context that is cancelled on the first SIGINT/SIGTERM.
+// A second signal falls through to the default handler and kills the process.
+func shutdownContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		signal.Stop(sigs)
+		textf("\nShutdown requested, finishing in-flight images... (press Ctrl+C again to force quit)\n")
+		Logger.Info("shutdown requested")
+		cancel()
+	}()
+	return ctx
+}
+
+// withMaxDuration returns a context that is also cancelled after -max-duration,
+// which then stops the run just like a shutdown request.
+func withMaxDuration(ctx context.Context) context.Context {
+	if MaxDuration <= 0 {
+		return ctx
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	time.AfterFunc(MaxDuration, func() {
+		if ctx.Err() == nil {
+			textf("\nMaximum run time of %v reached, finishing in-flight images...\n", MaxDuration)
+			Logger.Info("max duration reached", "max_duration", MaxDuration.String())
+		}
+		cancel()
+	})
+	return ctx
+}
+
+// sleepCtx sleeps for d or until ctx is cancelled, whichever comes first.
+func sleepCtx(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
+
+// ImmichRPS caps thumbnail downloads per second across all workers; 0 means unlimited.
+var ImmichRPS float64
+
+// immichLimiter enforces ImmichRPS; nil when unlimited.
+var immichLimiter *rate.Limiter
+
+// errNoThumbnail means Immich has no thumbnail for the asset, which retrying
+// won't change.
+var errNoThumbnail = errors.New("asset has no thumbnail")
+
+func downloadThumbnail(ctx context.Context, id string) ([]byte, error) {
+	u := fmt.Sprintf("%s/api/assets/%s/thumbnail?format=%s", ImmichBaseURL, id, ThumbnailFormat)
+	if ImageSize == "preview" {
+		u += "&size=preview"
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	setImmichAuth(req)
+	req.Header.Set("Accept", "application/octet-stream")
+
+	if immichLimiter != nil {
+		if err := immichLimiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	client := &http.Client{Timeout: DownloadTimeout, Transport: httpTransport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("status 404: %w", errNoThumbnail)
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// describeWithFallback tries each model in order until one produces a description.
+// It returns the description and the model that produced it; if every model
+// fails, the error lists each model's failure.
+func describeWithFallback(ctx context.Context, describer Describer, prompt string, images []string, models []string, policy RetryPolicy) (string, string, error) {
+	var errs []error
+	for i, model := range models {
+		desc, err := generateDescription(ctx, describer, prompt, images, model, policy)
+		if err == nil {
+			return desc, model, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", model, err))
+		if ctx.Err() != nil {
+			break
+		}
+		if i+1 < len(models) {
+			Logger.Debug("falling back", "failed_model", model, "next_model", models[i+1], "error", err.Error())
+		}
+	}
+	if len(errs) == 1 {
+		return "", "", errors.Unwrap(errs[0])
+	}
+	return "", "", errors.Join(errs...)
+}
+
+func generateDescription(ctx context.Context, describer Describer, prompt string, images []string, modelName string, policy RetryPolicy) (string, error) {
+	attempts := max(policy.MaxAttempts, 1)
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		reqCtx, cancel := context.WithTimeout(ctx, OllamaTimeout)
+		start := time.Now()
+		desc, err := describer.Describe(reqCtx, prompt, images, modelName)
+		metricOllamaDuration.Observe(time.Since(start).Seconds())
+		timedOut := errors.Is(reqCtx.Err(), context.DeadlineExceeded)
+		cancel()
+		if err == nil {
+			return desc, nil
+		}
+		if timedOut {
+			// A hung request is unlikely to succeed on retry; move on to the next asset.
+			return "", fmt.Errorf("request timed out after %v", OllamaTimeout)
+		}
+		lastErr = err
+
+		var retryable *retryableError
+		if !errors.As(err, &retryable) || attempt == attempts {
+			break
+		}
+		delay := policy.backoff(attempt)
+		if VerboseMode {
+			textf("   [RETRY] %s attempt %d/%d failed (%v), retrying in %v\n", modelName, attempt, attempts, err, delay.Round(time.Millisecond))
+		}
+		Logger.Debug("retrying", "model", modelName, "attempt", attempt, "max_attempts", attempts, "delay", delay.String(), "error", err.Error())
+		sleepCtx(ctx, delay)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return "", lastErr
+}
+
+// backoff returns the delay before the next attempt: exponential in the attempt
+// number, capped at MaxDelay, with up to 50% random jitter.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << (attempt - 1)
+	if delay <= 0 || (p.MaxDelay > 0 && delay > p.MaxDelay) {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	jitter := time.Duration(rand.Int64N(int64(delay)/2 + 1))
+	return delay/2 + jitter
+}
+
+// keepWebP reports whether data can be sent to the model as-is instead of
+// being converted: a still WebP requested with -thumbnail-format WEBP, for the
+// OpenAI backend, which accepts WebP (Ollama's runners don't reliably).
+func keepWebP(data []byte, maxDimension int) bool {
+	if ThumbnailFormat != "WEBP" || Backend != "openai" || webpChunks(data) == nil || frameCount(data) > 1 {
+		return false
+	}
+	if maxDimension > 0 {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil || cfg.Width > maxDimension || cfg.Height > maxDimension {
+			return false
+		}
+	}
+	return true
+}
+
+// ensureJPEG returns data as a JPEG, re-encoding with opts when the input is
+// another format, needs rotating or is larger than maxDimension (0 = no limit).
+// Upright JPEGs within the size limit are passed through untouched.
+func ensureJPEG(data []byte, opts *jpeg.Options, maxDimension int) ([]byte, error) {
+	if frame := webpFirstFrame(data); frame != nil {
+		data = frame
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		if reason := unsupportedFormat(data); reason != nil {
+			return nil, reason
+		}
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+	orientation := exifOrientation(data)
+	b := img.Bounds()
+	oversized := maxDimension > 0 && (b.Dx() > maxDimension || b.Dy() > maxDimension)
+	if format != "jpeg" || orientation > 1 || oversized {
+		img = applyOrientation(downscale(img, maxDimension), orientation)
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, opts); err != nil {
 			return nil, err
 		}
 		return buf.Bytes(), nil
 	}
 	return data, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsAddr is the listen address for the Prometheus endpoint; empty disables it.
+var MetricsAddr string
+
+// metricsServing is set once the metrics server runs, so that processing
+// several instances in one run starts it only once.
+var metricsServing bool
+
+var (
+	metricImagesProcessed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "images_processed_total",
+		Help: "Images whose description was generated and saved.",
+	})
+	metricImagesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "images_failed_total",
+		Help: "Images that failed, by pipeline step (download, conversion, ollama, db).",
+	}, []string{"reason"})
+	metricOllamaDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ollama_request_duration_seconds",
+		Help:    "Duration of individual model requests, including failed ones.",
+		Buckets: []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120, 300},
+	})
+	metricImagesPending = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "images_pending",
+		Help: "Images still without a description.",
+	})
+	metricScanCycles = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "watch_scan_cycles_total",
+		Help: "Scan cycles started: one per run, plus one per wake-up in watch mode.",
+	})
+	metricLastScan = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "last_scan_timestamp_seconds",
+		Help: "Unix time the last scan cycle started.",
+	})
+)
+
+// startMetricsServer serves /metrics on addr in the background. The listener is
+// opened synchronously so a bad address fails at startup.
+func startMetricsServer(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go http.Serve(ln, mux)
+	return nil
+}
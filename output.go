@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// Output selects what the run writes to stdout (-output): "text" (the default)
+// for the -log-format progress output, or "json" for a single JSON document at
+// the end of the run, for CI pipelines. In json mode the progress lines are
+// suppressed and -log-format json events go to stderr, so stdout holds only
+// the report.
+var Output string
+
+// assetOutcome is the entry for one asset in the -output json report.
+type assetOutcome struct {
+	AssetID    string `json:"assetId"`
+	Status     string `json:"status"` // saved, would_save, unchanged or failed
+	Reason     string `json:"reason,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Length     int    `json:"descriptionLength,omitempty"`
+	Model      string `json:"model,omitempty"`
+	CopiedFrom string `json:"copiedFrom,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// runSummary is the run-level part of the -output json report.
+type runSummary struct {
+	Processed       int      `json:"processed"`
+	Failed          int      `json:"failed"`
+	GaveUp          []string `json:"gaveUp"`
+	NoThumbnail     []string `json:"noThumbnail"`
+	BurstCopies     int64    `json:"burstCopies"`
+	Reused          int64    `json:"reused"`
+	Models          []string `json:"models"`
+	DryRun          bool     `json:"dryRun"`
+	StoppedEarly    bool     `json:"stoppedEarly"`
+	DurationSeconds float64  `json:"durationSeconds"`
+}
+
+// runReport collects the outcome of every asset for -output json.
+type runReport struct {
+	mu     sync.Mutex
+	assets []assetOutcome
+}
+
+// add records an asset's outcome. It does nothing on a nil report, so callers
+// don't have to check for -output json.
+func (r *runReport) add(o assetOutcome) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.assets = append(r.assets, o)
+}
+
+// write prints the report with the summary to stdout.
+func (r *runReport) write(summary runSummary) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	assets := r.assets
+	if assets == nil {
+		assets = []assetOutcome{}
+	}
+	if summary.GaveUp == nil {
+		summary.GaveUp = []string{}
+	}
+	if summary.NoThumbnail == nil {
+		summary.NoThumbnail = []string{}
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Summary runSummary     `json:"summary"`
+		Assets  []assetOutcome `json:"assets"`
+	}{summary, assets})
+}
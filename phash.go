@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"math/bits"
+	"slices"
+
+	"golang.org/x/image/draw"
+)
+
+// perceptualHash returns a 64-bit pHash of an encoded image: similar-looking
+// images get hashes that differ in only a few bits, regardless of size or
+// compression. The image is shrunk to 32x32 grey levels, and each bit says
+// whether one of the 8x8 lowest frequencies of its DCT is above their median.
+func perceptualHash(data []byte) (uint64, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	const n = 32
+	gray := image.NewGray(image.Rect(0, 0, n, n))
+	draw.ApproxBiLinear.Scale(gray, gray.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	// DCT-II along the rows, then along the columns, keeping 8x8 coefficients.
+	var rows [n][8]float64
+	for y := range n {
+		for u := range 8 {
+			sum := 0.0
+			for x := range n {
+				sum += float64(gray.Pix[y*gray.Stride+x]) * dctCos(x, u, n)
+			}
+			rows[y][u] = sum
+		}
+	}
+	var coeffs [64]float64
+	for v := range 8 {
+		for u := range 8 {
+			sum := 0.0
+			for y := range n {
+				sum += rows[y][u] * dctCos(y, v, n)
+			}
+			coeffs[v*8+u] = sum
+		}
+	}
+
+	// The DC term is the average brightness, which says nothing about the content.
+	sorted := slices.Clone(coeffs[1:])
+	slices.Sort(sorted)
+	median := sorted[len(sorted)/2]
+	var hash uint64
+	for i, c := range coeffs {
+		if i > 0 && c > median {
+			hash |= 1 << i
+		}
+	}
+	return hash, nil
+}
+
+func dctCos(x, u, n int) float64 {
+	return math.Cos(float64(2*x+1) * float64(u) * math.Pi / float64(2*n))
+}
+
+// hashDistance is the number of bits in which two perceptual hashes differ.
+func hashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+// testImage draws a diagonal gradient with a dark block in one corner, flipped
+// horizontally if mirror is set.
+func testImage(size int, mirror bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := range size {
+		for x := range size {
+			px := x
+			if mirror {
+				px = size - 1 - x
+			}
+			v := uint8((px + y) * 255 / (2 * size))
+			if px < size/3 && y < size/3 {
+				v = 20
+			}
+			img.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPerceptualHash(t *testing.T) {
+	orig := testImage(256, false)
+	small := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.CatmullRom.Scale(small, small.Bounds(), orig, orig.Bounds(), draw.Src, nil)
+	var lossy bytes.Buffer
+	if err := jpeg.Encode(&lossy, orig, &jpeg.Options{Quality: 40}); err != nil {
+		t.Fatal(err)
+	}
+
+	base, err := perceptualHash(encodePNG(t, orig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		data    []byte
+		maxDist int
+		minDist int
+	}{
+		{"same image", encodePNG(t, orig), 0, 0},
+		{"resized", encodePNG(t, small), 4, 0},
+		{"recompressed", lossy.Bytes(), 4, 0},
+		{"mirrored", encodePNG(t, testImage(256, true)), 64, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := perceptualHash(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := hashDistance(base, h); d < tt.minDist || d > tt.maxDist {
+				t.Errorf("distance = %d, want %d to %d", d, tt.minDist, tt.maxDist)
+			}
+		})
+	}
+
+	if _, err := perceptualHash([]byte("not an image")); err == nil {
+		t.Error("perceptualHash(garbage) succeeded, want an error")
+	}
+}
+
+func TestHashDistance(t *testing.T) {
+	tests := []struct {
+		a, b uint64
+		want int
+	}{
+		{0, 0, 0},
+		{0, 1, 1},
+		{0b1010, 0b0101, 4},
+		{^uint64(0), 0, 64},
+		{0xF0F0, 0xF0F0, 0},
+	}
+	for _, tt := range tests {
+		if got := hashDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("hashDistance(%#x, %#x) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
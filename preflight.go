@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// preflight checks the configuration before any work starts and prints a
+// summary with one line per check. It exits if anything failed, otherwise it
+// returns the connected database pool (nil with -source api) and the models installed in Ollama (nil
+// for the OpenAI backend, which isn't checked). Every model in required must
+// be installed.
+func preflight(ctx context.Context, required []string) (*pgxpool.Pool, []string) {
+	textf("Preflight:\n")
+	failed := false
+	check := func(name string, err error) {
+		if err != nil {
+			failed = true
+			textf("   ✗ %s: %v\n", name, err)
+			Logger.Error("preflight check failed", "check", name, "error", err.Error())
+			return
+		}
+		textf("   ✓ %s\n", name)
+		Logger.Debug("preflight check passed", "check", name)
+	}
+
+	if ImmichAPIKey == "" {
+		check("Immich API key", fmt.Errorf("IMMICH_API_KEY is not set (create one in Immich under Account Settings > API Keys)"))
+	} else {
+		check("Immich API key", nil)
+	}
+
+	installed, err := checkBackend(ctx)
+	check(fmt.Sprintf("%s reachable", backendName()), err)
+	if err == nil && installed != nil && len(required) > 0 {
+		if PullModel {
+			installed = pullMissing(ctx, required, installed)
+		}
+		check("Models installed", checkModels(required, installed))
+	}
+
+	var conn *pgxpool.Pool
+	if Source == "api" {
+		check("Immich API", checkImmichAPI(ctx))
+	} else if conn, err = connectDB(ctx); err != nil {
+		check("Database connection", fmt.Errorf("%v (URL: %s; check DB_HOST, DB_PORT and DB_PASS or -db-password-file)", err, PostgresURLRedacted))
+	} else {
+		check("Database connection", nil)
+		check("Immich tables", checkTables(ctx, conn, "asset", "asset_exif"))
+	}
+
+	if failed {
+		log.Fatal("Preflight failed, fix the problems above and try again")
+	}
+	return conn, installed
+}
+
+func backendName() string {
+	if Backend == "openai" {
+		return "OpenAI API"
+	}
+	return "Ollama"
+}
+
+// checkBackend makes a cheap request to the model server to verify it is
+// reachable and, for OpenAI, that the key is accepted. For Ollama it returns
+// the names of the installed models.
+func checkBackend(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	endpoint := OllamaHost + "/api/tags"
+	if Backend == "openai" {
+		endpoint = strings.TrimRight(OpenAIBaseURL, "/") + "/models"
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if Backend == "openai" && OpenAIAPIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+OpenAIAPIKey)
+	}
+	resp, err := (&http.Client{Transport: httpTransport}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach %s: %v", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return nil, fmt.Errorf("%s rejected the API key (status 401; check OPENAI_API_KEY)", endpoint)
+	case resp.StatusCode != 200:
+		return nil, fmt.Errorf("%s returned status %d", endpoint, resp.StatusCode)
+	}
+	if Backend == "openai" {
+		return nil, nil
+	}
+
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("unexpected response from %s: %v", endpoint, err)
+	}
+	installed := []string{}
+	for _, m := range tags.Models {
+		installed = append(installed, m.Name)
+	}
+	return installed, nil
+}
+
+// modelInstalled reports whether name is in installed. Like Ollama, a name
+// without a tag refers to ":latest".
+func modelInstalled(name string, installed []string) bool {
+	if !strings.Contains(name, ":") {
+		name += ":latest"
+	}
+	for _, m := range installed {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkModels verifies that every required model is installed, listing the
+// available ones otherwise.
+func checkModels(required, installed []string) error {
+	var missing []string
+	for _, m := range required {
+		if !modelInstalled(m, installed) {
+			missing = append(missing, m)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	available := "none"
+	if len(installed) > 0 {
+		available = strings.Join(installed, ", ")
+	}
+	return fmt.Errorf("not installed: %s (run `ollama pull <model>`). Available: %s", strings.Join(missing, ", "), available)
+}
+
+// checkTables verifies that the given tables exist, i.e. that we are pointed at
+// an Immich database.
+func checkTables(ctx context.Context, conn *pgxpool.Pool, tables ...string) error {
+	var missing []string
+	for _, t := range tables {
+		var ok bool
+		if err := conn.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL`, t).Scan(&ok); err != nil {
+			return err
+		}
+		if !ok {
+			missing = append(missing, t)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing %s (is DB_NAME the Immich database, and is Immich up to date?)", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Quiet replaces the per-asset status lines with a single progress bar.
+var Quiet bool
+
+// ProgressInterval is how often a throughput and ETA line is printed during
+// a run (-progress-interval, 0 = never). In -quiet mode the progress bar shows
+// the same numbers instead.
+var ProgressInterval time.Duration
+
+// rateWindow is the period over which the throughput is averaged, so the ETA
+// follows changes in speed (e.g. a slower fallback model) instead of the
+// average of the whole run.
+const rateWindow = 5 * time.Minute
+
+// rollingRate measures the rate of completed assets over the last rateWindow.
+type rollingRate struct {
+	start time.Time
+	done  []time.Time // completion times within the window
+}
+
+func newRollingRate() rollingRate {
+	return rollingRate{start: time.Now()}
+}
+
+func (r *rollingRate) add() {
+	r.done = append(r.done, time.Now())
+}
+
+// perMinute returns the number of assets completed per minute.
+func (r *rollingRate) perMinute() float64 {
+	now := time.Now()
+	cutoff := now.Add(-rateWindow)
+	i := 0
+	for i < len(r.done) && r.done[i].Before(cutoff) {
+		i++
+	}
+	r.done = r.done[i:]
+	span := min(now.Sub(r.start), rateWindow)
+	if span <= 0 {
+		return 0
+	}
+	return float64(len(r.done)) / span.Minutes()
+}
+
+// eta returns the time needed for remaining assets at rate per minute, or 0
+// if it can't be estimated.
+func eta(remaining int64, rate float64) time.Duration {
+	if remaining <= 0 || rate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(remaining) / rate * float64(time.Minute)).Round(time.Second)
+}
+
+// throughput prints the periodic throughput and ETA line of -progress-interval.
+type throughput struct {
+	mu      sync.Mutex
+	rate    rollingRate
+	pending int64 // assets pending when last counted, -1 if unknown
+	done    int64 // assets completed since then
+	last    time.Time
+}
+
+func newThroughput() *throughput {
+	return &throughput{rate: newRollingRate(), pending: -1, last: time.Now()}
+}
+
+// reset starts counting down from a fresh pending count (-1 if unknown).
+func (t *throughput) reset(pending int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending, t.done = pending, 0
+}
+
+// add counts a completed asset and prints the status line if it is due.
+func (t *throughput) add() {
+	if ProgressInterval <= 0 || Quiet {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rate.add()
+	t.done++
+	if time.Since(t.last) < ProgressInterval {
+		return
+	}
+	t.last = time.Now()
+	rate := t.rate.perMinute()
+	if t.pending < 0 {
+		textf("[Progress] %.1f images/min\n", rate)
+		Logger.Info("progress", "images_per_minute", rate)
+		return
+	}
+	remaining := max(t.pending-t.done, 0)
+	line := fmt.Sprintf("[Progress] %.1f images/min, %d remaining", rate, remaining)
+	if d := eta(remaining, rate); d > 0 {
+		line += ", ETA " + d.String()
+	}
+	textf("%s\n", line)
+	Logger.Info("progress", "images_per_minute", rate, "remaining", remaining, "eta_seconds", eta(remaining, rate).Seconds())
+}
+
+// progress is the active progress bar in -quiet mode, nil otherwise. textf
+// clears and redraws it around every line it prints.
+var progress *progressBar
+
+// progressBar renders "N/total, percent, rate and ETA" on one line, redrawn in
+// place with a carriage return. When stdout is not a terminal it prints a plain
+// line every 30 seconds instead.
+type progressBar struct {
+	mu       sync.Mutex
+	total    int // 0 when unknown (watch mode)
+	done     int
+	failed   int
+	terminal bool
+	lastDraw time.Time
+	rate     rollingRate
+}
+
+// startProgress shows a progress bar for total assets (0 = unknown).
+func startProgress(total int) {
+	if LogFormat == "json" || Output == "json" {
+		return
+	}
+	progress = newProgressBar(total)
+}
+
+// stopProgress finishes the bar so that later output starts on a fresh line.
+func stopProgress() {
+	progress.finish()
+	progress = nil
+}
+
+func newProgressBar(total int) *progressBar {
+	fi, err := os.Stdout.Stat()
+	return &progressBar{
+		total:    total,
+		terminal: err == nil && fi.Mode()&os.ModeCharDevice != 0,
+		rate:     newRollingRate(),
+	}
+}
+
+// add counts a finished asset and redraws the bar.
+func (b *progressBar) add(ok bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done++
+	if ok {
+		b.rate.add()
+	} else {
+		b.failed++
+	}
+	b.draw(false)
+}
+
+// print writes a regular output line without garbling the bar.
+func (b *progressBar) print(s string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.terminal {
+		fmt.Print("\r\033[K")
+	}
+	fmt.Print(s)
+	if b.terminal {
+		b.draw(true)
+	}
+}
+
+// finish leaves the final state of the bar on its own line.
+func (b *progressBar) finish() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.draw(true)
+	if b.terminal {
+		fmt.Println()
+	}
+}
+
+func (b *progressBar) draw(force bool) {
+	interval := 200 * time.Millisecond
+	if !b.terminal {
+		interval = 30 * time.Second
+	}
+	if !force && time.Since(b.lastDraw) < interval {
+		return
+	}
+	b.lastDraw = time.Now()
+
+	rate := b.rate.perMinute()
+	line := fmt.Sprintf("%d", b.done)
+	if b.total > 0 {
+		pct := min(float64(b.done)/float64(b.total), 1)
+		width := 30
+		filled := int(pct * float64(width))
+		line = fmt.Sprintf("[%s%s] %d/%d %.1f%%", strings.Repeat("#", filled), strings.Repeat(".", width-filled), b.done, b.total, pct*100)
+	}
+	line += fmt.Sprintf(" | %.1f img/min", rate)
+	if b.failed > 0 {
+		line += fmt.Sprintf(" | %d failed", b.failed)
+	}
+	if d := eta(int64(b.total-b.done), rate); b.total > 0 && d > 0 {
+		line += " | ETA " + d.String()
+	}
+
+	if b.terminal {
+		fmt.Print("\r\033[K" + line)
+	} else {
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PullModel pulls models that aren't installed in Ollama during preflight
+// instead of failing (-pull-model).
+var PullModel bool
+
+// pullMissing pulls every model in required that isn't in installed and
+// returns installed with the pulled models added. Failed pulls are left out,
+// so the regular model check reports them.
+func pullMissing(ctx context.Context, required, installed []string) []string {
+	for _, name := range required {
+		if modelInstalled(name, installed) {
+			continue
+		}
+		textf("   Pulling %s...\n", name)
+		Logger.Info("pulling model", "model", name)
+		start := time.Now()
+		if err := pullModel(ctx, name); err != nil {
+			textf("   [WARN] Failed to pull %s: %v\n", name, err)
+			Logger.Error("model pull failed", "model", name, "error", err.Error())
+			continue
+		}
+		textf("   Pulled %s in %v\n", name, time.Since(start).Round(time.Second))
+		Logger.Info("model pulled", "model", name, "duration_s", time.Since(start).Seconds())
+		if !strings.Contains(name, ":") {
+			name += ":latest" // as Ollama lists it
+		}
+		installed = append(installed, name)
+	}
+	return installed
+}
+
+// pullProgress is one line of the NDJSON stream returned by /api/pull.
+type pullProgress struct {
+	Status    string `json:"status"`
+	Digest    string `json:"digest"`
+	Total     int64  `json:"total"`
+	Completed int64  `json:"completed"`
+	Error     string `json:"error"`
+}
+
+// pullModel downloads name through Ollama's /api/pull, printing the progress.
+// Pulls can take far longer than a description, so there is no overall time
+// limit: -ollama-timeout only applies to a stalled pull, i.e. one without any
+// progress update for that long.
+func pullModel(ctx context.Context, name string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stalled := time.AfterFunc(OllamaTimeout, cancel)
+	defer stalled.Stop()
+
+	jsonData, _ := json.Marshal(map[string]any{"model": name, "stream": true})
+	req, err := http.NewRequestWithContext(ctx, "POST", OllamaHost+"/api/pull", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := (&http.Client{Transport: httpTransport}).Do(req)
+	if err != nil {
+		return stallError(ctx, stalled, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("ollama status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+
+	lastStatus, lastPct := "", -1
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		stalled.Reset(OllamaTimeout)
+		var p pullProgress
+		if err := json.Unmarshal(sc.Bytes(), &p); err != nil {
+			return fmt.Errorf("unexpected response from /api/pull: %v", err)
+		}
+		if p.Error != "" {
+			return fmt.Errorf("%s", p.Error)
+		}
+		if p.Status != lastStatus {
+			lastStatus, lastPct = p.Status, -1
+			if p.Total == 0 {
+				textf("      %s\n", p.Status)
+			}
+		}
+		// Layer downloads report their size; show them in 10% steps.
+		if p.Total > 0 {
+			if pct := int(p.Completed * 100 / p.Total); pct/10 > lastPct/10 {
+				lastPct = pct
+				textf("      %s: %d%% (%.1f / %.1f MB)\n", p.Status, pct, float64(p.Completed)/1e6, float64(p.Total)/1e6)
+			}
+		}
+		if p.Status == "success" {
+			return nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return stallError(ctx, stalled, err)
+	}
+	return fmt.Errorf("pull ended before it completed")
+}
+
+// stallError replaces the error of a request cancelled by the stall timer.
+func stallError(ctx context.Context, stalled *time.Timer, err error) error {
+	if ctx.Err() != nil && !stalled.Stop() {
+		return fmt.Errorf("no progress for %v (-ollama-timeout)", OllamaTimeout)
+	}
+	return err
+}
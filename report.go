@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrorReport is the path of the JSON error report written at the end of a
+// run; empty disables it.
+var ErrorReport string
+
+// failedAsset is an asset that could not be described in this run.
+type failedAsset struct {
+	AssetID  string `json:"assetId"`
+	Reason   string `json:"reason"` // download, conversion, ollama, empty response, too short or db
+	Error    string `json:"error"`
+	Attempts int    `json:"attempts"`
+
+	last time.Time // of the latest failure
+}
+
+// errorReportFile is the on-disk format of -error-report.
+type errorReportFile struct {
+	GeneratedAt time.Time     `json:"generatedAt"`
+	Failures    []failedAsset `json:"failures"`
+}
+
+// failureLog collects the latest failure of every asset that failed during the
+// run. Assets that succeed on a later attempt are dropped again.
+type failureLog struct {
+	mu    sync.Mutex
+	order []string
+	byID  map[string]*failedAsset
+
+	// carried are the failures read with -reprocess-errors. Those not
+	// attempted before the run stops are written to the report again.
+	carried   []failedAsset
+	attempted map[string]bool
+}
+
+func newFailureLog(carried []failedAsset) *failureLog {
+	return &failureLog{byID: make(map[string]*failedAsset), carried: carried, attempted: make(map[string]bool)}
+}
+
+func (l *failureLog) add(assetID, reason string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.attempted[assetID] = true
+	f, ok := l.byID[assetID]
+	if !ok {
+		f = &failedAsset{AssetID: assetID}
+		l.byID[assetID] = f
+		l.order = append(l.order, assetID)
+	}
+	f.Reason = reason
+	f.Error = err.Error()
+	f.Attempts++
+	f.last = time.Now()
+}
+
+// resolve drops an asset that succeeded, or that no longer needs a retry.
+func (l *failureLog) resolve(assetID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.attempted[assetID] = true
+	if _, ok := l.byID[assetID]; !ok {
+		return
+	}
+	delete(l.byID, assetID)
+	for i, id := range l.order {
+		if id == assetID {
+			l.order = append(l.order[:i], l.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// list returns the failures in the order the assets first failed.
+func (l *failureLog) list() []failedAsset {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]failedAsset, 0, len(l.order))
+	for _, id := range l.order {
+		out = append(out, *l.byID[id])
+	}
+	return out
+}
+
+// failedSince returns the number of assets that failed since t and haven't
+// succeeded since, e.g. those of the current watch cycle.
+func (l *failureLog) failedSince(t time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, f := range l.byID {
+		if !f.last.Before(t) {
+			n++
+		}
+	}
+	return n
+}
+
+// printGrouped prints the failures grouped by reason.
+func (l *failureLog) printGrouped() {
+	failures := l.list()
+	if len(failures) == 0 {
+		return
+	}
+	byReason := make(map[string][]failedAsset)
+	var reasons []string
+	for _, f := range failures {
+		if _, ok := byReason[f.Reason]; !ok {
+			reasons = append(reasons, f.Reason)
+		}
+		byReason[f.Reason] = append(byReason[f.Reason], f)
+	}
+	sort.Strings(reasons)
+
+	textf("Failed: %d images\n", len(failures))
+	for _, reason := range reasons {
+		textf("   %s: %d\n", reason, len(byReason[reason]))
+		for _, f := range byReason[reason] {
+			textf("      - %s: %s\n", f.AssetID, f.Error)
+		}
+	}
+}
+
+// write saves the failures to path as JSON, replacing any previous report.
+// Carried failures that weren't attempted, e.g. because the run was
+// interrupted, are kept as they were.
+func (l *failureLog) write(path string) error {
+	failures := l.list()
+	l.mu.Lock()
+	for _, f := range l.carried {
+		if !l.attempted[f.AssetID] {
+			failures = append(failures, f)
+		}
+	}
+	l.mu.Unlock()
+	data, err := json.MarshalIndent(errorReportFile{GeneratedAt: time.Now().UTC(), Failures: failures}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// reprocessFailures are the failures read from the -reprocess-errors report.
+var reprocessFailures []failedAsset
+
+// readErrorReport returns the failures listed in a report written by -error-report.
+func readErrorReport(path string) ([]failedAsset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var report errorReportFile
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("%s is not an error report: %v", path, err)
+	}
+	for i, f := range report.Failures {
+		// Asset IDs are lower case everywhere else.
+		report.Failures[i].AssetID = strings.ToLower(f.AssetID)
+	}
+	return report.Failures, nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// downscale shrinks img so its longest side is at most maxDim pixels,
+// preserving the aspect ratio. Images already within the limit (or maxDim <= 0)
+// are returned unchanged.
+func downscale(img image.Image, maxDim int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if maxDim <= 0 || (w <= maxDim && h <= maxDim) {
+		return img
+	}
+	nw, nh := maxDim, h*maxDim/w
+	if h > w {
+		nw, nh = w*maxDim/h, maxDim
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, max(nw, 1), max(nh, 1)))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PHashReuseThreshold enables reusing descriptions of similar images
+// (-phash-reuse-threshold): an asset whose perceptual hash differs from that
+// of an already described asset in at most this many bits gets its
+// description instead of a new one. 0 disables it.
+var PHashReuseThreshold int
+
+// reuseIndex holds the perceptual hashes of described assets: those recorded
+// in metaTable by earlier runs, and those described during this run.
+type reuseIndex struct {
+	mu      sync.Mutex
+	entries []reuseEntry
+}
+
+type reuseEntry struct {
+	assetID string
+	hash    uint64
+	res     *assetResult // described during this run; nil if loaded from the database
+}
+
+// loadReuseIndex reads the perceptual hashes stored by earlier runs.
+func loadReuseIndex(ctx context.Context, conn *pgxpool.Pool) (*reuseIndex, error) {
+	rows, err := conn.Query(ctx, `SELECT "assetId"::text, "perceptualHash" FROM `+metaTable+` WHERE "perceptualHash" IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	idx := &reuseIndex{}
+	for rows.Next() {
+		var e reuseEntry
+		var hash int64
+		if err := rows.Scan(&e.assetID, &hash); err != nil {
+			return nil, err
+		}
+		e.hash = uint64(hash)
+		idx.entries = append(idx.entries, e)
+	}
+	return idx, rows.Err()
+}
+
+// nearest returns the closest described asset other than assetID within
+// PHashReuseThreshold, if any.
+func (r *reuseIndex) nearest(assetID string, hash uint64) (reuseEntry, int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	best, bestDist := reuseEntry{}, PHashReuseThreshold+1
+	for _, e := range r.entries {
+		if d := hashDistance(e.hash, hash); d < bestDist && e.assetID != assetID {
+			best, bestDist = e, d
+		}
+	}
+	return best, bestDist, best.assetID != ""
+}
+
+// add records a description saved during this run.
+func (r *reuseIndex) add(res assetResult, hash uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, reuseEntry{assetID: res.ID, hash: hash, res: &res})
+}
+
+// storedDescription returns the saved description of an asset and the model
+// that generated it, or "" if it has none (anymore).
+func storedDescription(ctx context.Context, conn *pgxpool.Pool, assetID string) (desc, model string, err error) {
+	err = conn.QueryRow(ctx, `
+		SELECT COALESCE(`+target.value()+`, ''), m.model
+		FROM `+metaTable+` m
+		JOIN asset a ON a.id = m."assetId"
+		LEFT JOIN asset_exif ae ON ae."assetId" = a.id
+		WHERE m."assetId" = $1
+	`, assetID).Scan(&desc, &model)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", nil
+	}
+	return desc, model, err
+}
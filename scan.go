@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// assetFilter accumulates WHERE conditions and their positional arguments
+// for queries over asset a LEFT JOIN asset_exif ae. Assets without an
+// asset_exif row (some imports) have NULL ae columns.
+type assetFilter struct {
+	conds []string
+	args  []any
+}
+
+// arg registers a query argument and returns its placeholder ($1, $2, ...).
+func (f *assetFilter) arg(v any) string {
+	f.args = append(f.args, v)
+	return fmt.Sprintf("$%d", len(f.args))
+}
+
+func (f *assetFilter) where(cond string) {
+	f.conds = append(f.conds, cond)
+}
+
+func (f *assetFilter) sql() string {
+	if len(f.conds) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(f.conds, "\n\t\t\tAND ")
+}
+
+// pendingFilter selects the assets this run should describe, according to the
+// configured flags. It is shared by the scan and the pending count so both
+// always agree.
+func pendingFilter() *assetFilter {
+	f := &assetFilter{}
+	if !Overwrite {
+		// Also true without an asset_exif row, which saving creates.
+		f.where(fmt.Sprintf(`(%[1]s IS NULL OR %[1]s = '')`, target.value()))
+	}
+	if OverwriteByModel != "" {
+		f.where(fmt.Sprintf(`a.id IN (SELECT m."assetId" FROM %s m WHERE m.model = %s)`, metaTable, f.arg(OverwriteByModel)))
+	}
+	if OnlyFavorites {
+		f.where(`a."isFavorite" = true`)
+	}
+	if IncludeVideos {
+		f.where(`a.type IN ('IMAGE', 'VIDEO')`)
+	} else {
+		f.where(`a.type = 'IMAGE'`)
+	}
+	if AlbumID != "" {
+		f.where(fmt.Sprintf(`a.id IN (SELECT aa."assetId" FROM album_asset aa WHERE aa."albumId" = %s::uuid)`, f.arg(AlbumID)))
+	}
+	if NotInAlbumID != "" {
+		f.where(fmt.Sprintf(`NOT EXISTS (SELECT 1 FROM album_asset aa WHERE aa."assetId" = a.id AND aa."albumId" = %s::uuid)`, f.arg(NotInAlbumID)))
+	}
+	if !CreatedAfter.IsZero() {
+		f.where(fmt.Sprintf(`a."createdAt" > %s`, f.arg(CreatedAfter)))
+	}
+	if OwnerID != "" {
+		f.where(fmt.Sprintf(`a."ownerId" = %s::uuid`, f.arg(OwnerID)))
+	}
+	if len(ExcludeTags) > 0 {
+		f.where(excludeTagCond(f))
+	}
+	if DescribeStacks {
+		f.where(stackCond)
+	}
+	if !DateFrom.IsZero() {
+		f.where(fmt.Sprintf(`COALESCE(ae."dateTimeOriginal", a."createdAt") >= %s`, f.arg(DateFrom)))
+	}
+	if !DateTo.IsZero() {
+		// -to is inclusive: everything before the start of the following day.
+		f.where(fmt.Sprintf(`COALESCE(ae."dateTimeOriginal", a."createdAt") < %s`, f.arg(DateTo.AddDate(0, 0, 1))))
+	}
+	return f
+}
+
+// excludeTagCond returns the condition that rejects assets carrying one of
+// ExcludeTags or a tag nested below one of them (e.g. "private/no-ai" for
+// "private"), compared case-insensitively. Tags are matched by name in every
+// query, so tags created while watching are honoured too.
+func excludeTagCond(f *assetFilter) string {
+	names := make([]string, len(ExcludeTags))
+	for i, t := range ExcludeTags {
+		names[i] = strings.ToLower(strings.Trim(t, "/"))
+	}
+	return fmt.Sprintf(`NOT EXISTS (
+				SELECT 1 FROM tag_asset ta
+				JOIN tag t ON t.id = ta."tagsId"
+				JOIN unnest(%s::text[]) x(name) ON lower(t.value) = x.name OR left(lower(t.value), length(x.name) + 1) = x.name || '/'
+				WHERE ta."assetsId" = a.id
+			)`, f.arg(names))
+}
+
+// checkExcludeTags verifies that every -exclude-tag exists, so a misspelled
+// tag doesn't silently let private photos through.
+func checkExcludeTags(ctx context.Context, conn *pgxpool.Pool) error {
+	for _, name := range ExcludeTags {
+		var ok bool
+		err := conn.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM tag WHERE lower(value) = lower($1))`, strings.Trim(name, "/")).Scan(&ok)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("-exclude-tag: tag %q not found (nested tags need their full name, e.g. parent/child)", name)
+		}
+	}
+	return nil
+}
+
+// excludedByTag returns which of the given assets carry an -exclude-tag.
+func excludedByTag(ctx context.Context, conn *pgxpool.Pool, assets []assetRef) (map[string]bool, error) {
+	excluded := make(map[string]bool)
+	if len(ExcludeTags) == 0 || len(assets) == 0 {
+		return excluded, nil
+	}
+	f := &assetFilter{}
+	ids := make([]string, len(assets))
+	for i, a := range assets {
+		ids[i] = a.ID
+	}
+	query := fmt.Sprintf(`SELECT a.id::text FROM asset a WHERE a.id::text = ANY(%s::text[]) AND NOT %s`, f.arg(ids), excludeTagCond(f))
+	rows, err := conn.Query(ctx, query, f.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		excluded[id] = true
+	}
+	return excluded, rows.Err()
+}
+
+var uuidPattern = regexp.MustCompile(`(?i)^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// resolveAlbum looks up an album by ID or (case-insensitive) name and returns
+// its ID and name. If nothing matches, the error lists the available albums.
+func resolveAlbum(ctx context.Context, conn *pgxpool.Pool, album string) (string, string, error) {
+	if Source == "api" {
+		return apiResolveAlbum(ctx, album)
+	}
+	rows, err := conn.Query(ctx, `
+		SELECT id::text, "albumName"
+		FROM album
+		WHERE "deletedAt" IS NULL
+		ORDER BY "albumName"
+	`)
+	if err != nil {
+		return "", "", err
+	}
+	defer rows.Close()
+
+	var albums [][2]string
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return "", "", err
+		}
+		albums = append(albums, [2]string{id, name})
+	}
+	if err := rows.Err(); err != nil {
+		return "", "", err
+	}
+	return matchAlbum(album, albums)
+}
+
+// matchAlbum picks album from the given (ID, name) pairs, sorted by name.
+func matchAlbum(album string, albums [][2]string) (string, string, error) {
+	var names []string
+	var matches [][2]string
+	for _, a := range albums {
+		names = append(names, a[1])
+		if (uuidPattern.MatchString(album) && strings.EqualFold(a[0], album)) || strings.EqualFold(a[1], album) {
+			matches = append(matches, a)
+		}
+	}
+
+	switch len(matches) {
+	case 1:
+		return matches[0][0], matches[0][1], nil
+	case 0:
+		if len(names) == 0 {
+			return "", "", fmt.Errorf("album %q not found (there are no albums)", album)
+		}
+		return "", "", fmt.Errorf("album %q not found. Available albums:\n   - %s", album, strings.Join(names, "\n   - "))
+	default:
+		var ids []string
+		for _, m := range matches {
+			ids = append(ids, m[0])
+		}
+		return "", "", fmt.Errorf("album name %q is ambiguous, use one of these IDs: %s", album, strings.Join(ids, ", "))
+	}
+}
+
+// resolveOwner looks up a user by ID or (case-insensitive) email and returns
+// their ID and name.
+func resolveOwner(ctx context.Context, conn *pgxpool.Pool, owner string) (string, string, error) {
+	var id, name string
+	err := conn.QueryRow(ctx, `
+		SELECT id::text, name
+		FROM "user"
+		WHERE "deletedAt" IS NULL
+			AND (id::text = lower($1) OR lower(email) = lower($1))
+	`, owner).Scan(&id, &name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", fmt.Errorf("owner %q not found (use the user's email or ID)", owner)
+	}
+	return id, name, err
+}
+
+// assetRef identifies an asset to process and its type ("IMAGE" or "VIDEO").
+type assetRef struct {
+	ID        string
+	Type      string
+	CreatedAt time.Time // only set by scanAssets
+}
+
+// scanCursor is the position of the last asset returned by a scan, used to
+// page through assets whose description stays non-empty after processing
+// (overwrite mode), which would otherwise be selected again and again.
+type scanCursor struct {
+	Favorite  bool
+	CreatedAt time.Time
+	ID        string
+}
+
+// scanAssets returns the next batch of assets to process, skipping excluded IDs
+// and, when after is set, everything up to and including that position.
+func scanAssets(ctx context.Context, conn *pgxpool.Pool, excluded []string, after *scanCursor, limit int) ([]assetRef, *scanCursor, error) {
+	f := pendingFilter()
+	f.where(fmt.Sprintf("NOT (a.id::text = ANY(%s::text[]))", f.arg(excluded)))
+	dir := orderDirection()
+	if after != nil {
+		cmp := "<"
+		if dir == "ASC" {
+			cmp = ">"
+		}
+		cond := fmt.Sprintf(`(a."createdAt", a.id) %s (%s, %s::uuid)`, cmp, f.arg(after.CreatedAt), f.arg(after.ID))
+		if FavoritesFirst {
+			// Favorites sort first regardless of -order, so the flag gets its own comparison.
+			fav := f.arg(after.Favorite)
+			cond = fmt.Sprintf(`(a."isFavorite" < %s OR (a."isFavorite" = %s AND %s))`, fav, fav, cond)
+		}
+		f.where(cond)
+	}
+	order := fmt.Sprintf(`a."createdAt" %s, a.id %s`, dir, dir)
+	if FavoritesFirst {
+		order = `a."isFavorite" DESC, ` + order
+	}
+	query := fmt.Sprintf(`
+		SELECT a.id, a.type, a."isFavorite", a."createdAt"
+		FROM asset a
+		LEFT JOIN asset_exif ae ON a.id = ae."assetId"
+		%s
+		ORDER BY %s
+		LIMIT %s
+	`, f.sql(), order, f.arg(limit))
+
+	rows, err := conn.Query(ctx, query, f.args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var assets []assetRef
+	var last *scanCursor
+	for rows.Next() {
+		var c scanCursor
+		var assetType string
+		if err := rows.Scan(&c.ID, &assetType, &c.Favorite, &c.CreatedAt); err != nil {
+			return nil, nil, err
+		}
+		assets = append(assets, assetRef{ID: c.ID, Type: assetType, CreatedAt: c.CreatedAt})
+		last = &c
+	}
+	return assets, last, rows.Err()
+}
+
+// lookupAssets resolves explicitly requested IDs, in the given order. Unknown IDs are an error.
+func lookupAssets(ctx context.Context, conn *pgxpool.Pool, ids []string) ([]assetRef, error) {
+	assets, missing, err := findAssets(ctx, conn, ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("asset %s not found", missing[0])
+	}
+	return assets, nil
+}
+
+// findAssets resolves the given IDs, in order, and also returns those that
+// don't exist (anymore).
+func findAssets(ctx context.Context, conn *pgxpool.Pool, ids []string) ([]assetRef, []string, error) {
+	if Source == "api" {
+		return apiFindAssets(ctx, ids)
+	}
+	lower := make([]string, len(ids))
+	for i, id := range ids {
+		if !uuidPattern.MatchString(id) {
+			return nil, nil, fmt.Errorf("invalid asset ID %q", id)
+		}
+		lower[i] = strings.ToLower(id)
+	}
+	rows, err := conn.Query(ctx, `SELECT id::text, type FROM asset WHERE id::text = ANY($1::text[])`, lower)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	types := make(map[string]string)
+	for rows.Next() {
+		var id, assetType string
+		if err := rows.Scan(&id, &assetType); err != nil {
+			return nil, nil, err
+		}
+		types[id] = assetType
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	assets := make([]assetRef, 0, len(ids))
+	var missing []string
+	for _, id := range ids {
+		assetType, ok := types[strings.ToLower(id)]
+		if !ok {
+			missing = append(missing, id)
+			continue
+		}
+		assets = append(assets, assetRef{ID: id, Type: assetType})
+	}
+	return assets, missing, nil
+}
+
+// countPending returns the number of assets that still need a description.
+func countPending(ctx context.Context, conn *pgxpool.Pool) (int64, error) {
+	if Source == "api" {
+		counts, err := apiCount(ctx, apiAsset.pending)
+		return counts["IMAGE"] + counts["VIDEO"], err
+	}
+	f := pendingFilter()
+	query := fmt.Sprintf(`
+		SELECT COUNT(*)
+		FROM asset a
+		LEFT JOIN asset_exif ae ON a.id = ae."assetId"
+		%s
+	`, f.sql())
+	var n int64
+	err := conn.QueryRow(ctx, query, f.args...).Scan(&n)
+	return n, err
+}
+
+// countOverwritten returns the number of pending assets that already have a
+// description, i.e. how many descriptions an -overwrite run would replace.
+func countOverwritten(ctx context.Context, conn *pgxpool.Pool) (int64, error) {
+	if Source == "api" {
+		counts, err := apiCount(ctx, func(a apiAsset) bool { return a.pending() && a.description() != "" })
+		return counts["IMAGE"] + counts["VIDEO"], err
+	}
+	f := pendingFilter()
+	f.where(fmt.Sprintf(`COALESCE(%s, '') <> ''`, target.value()))
+	query := fmt.Sprintf(`
+		SELECT COUNT(*)
+		FROM asset a
+		LEFT JOIN asset_exif ae ON a.id = ae."assetId"
+		%s
+	`, f.sql())
+	var n int64
+	err := conn.QueryRow(ctx, query, f.args...).Scan(&n)
+	return n, err
+}
+
+// countPendingByType returns the number of assets that still need a
+// description, keyed by asset type (IMAGE, VIDEO).
+func countPendingByType(ctx context.Context, conn *pgxpool.Pool) (map[string]int64, error) {
+	if Source == "api" {
+		return apiCount(ctx, apiAsset.pending)
+	}
+	f := pendingFilter()
+	query := fmt.Sprintf(`
+		SELECT a.type, COUNT(*)
+		FROM asset a
+		LEFT JOIN asset_exif ae ON a.id = ae."assetId"
+		%s
+		GROUP BY a.type
+	`, f.sql())
+	rows, err := conn.Query(ctx, query, f.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var assetType string
+		var n int64
+		if err := rows.Scan(&assetType, &n); err != nil {
+			return nil, err
+		}
+		counts[assetType] = n
+	}
+	return counts, rows.Err()
+}
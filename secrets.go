@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// readSecret returns the contents of a file holding a password or API key,
+// such as a mounted Docker or Kubernetes secret, without the trailing newline.
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimRight(string(data), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return secret, nil
+}
+
+// loadSecretFile replaces *value with the secret in path, if the -<flagName>
+// option is set. The file wins over a value given inline; a file that can't be
+// read is fatal.
+func loadSecretFile(flagName, path string, value *string) {
+	if path == "" {
+		return
+	}
+	secret, err := readSecret(path)
+	if err != nil {
+		log.Fatalf("Failed to read -%s: %v", flagName, err)
+	}
+	*value = secret
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServeAddr is the listen address for the REST API (-serve); empty disables it.
+var ServeAddr string
+
+// server exposes the processing pipeline over HTTP:
+//
+//	POST /describe {"assetId": "..."}  describe one asset and return the result
+//	GET  /pending                      number of assets still to describe
+//	POST /run                          start a full scan in the background
+type server struct {
+	proc *processor
+
+	mu      sync.Mutex
+	running bool
+	scans   sync.WaitGroup
+}
+
+func runServer() {
+	textf("Using model: %s\n", strings.Join(Models, " -> "))
+	Logger.Info("server starting", "addr", ServeAddr, "models", Models, "workers", Workers, "dry_run", DryRun)
+	ctx := shutdownContext()
+
+	proc := newProcessor(ctx)
+	if proc == nil {
+		textf("Aborted.\n")
+		return
+	}
+	s := &server{proc: proc}
+	if s.proc.conn != nil {
+		defer s.proc.conn.Close()
+	}
+	// API callers expect the description to be saved when the response arrives.
+	s.proc.commitBatch = 1
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /describe", s.handleDescribe)
+	mux.HandleFunc("GET /pending", s.handlePending)
+	mux.HandleFunc("POST /run", s.handleRun)
+	srv := &http.Server{Addr: ServeAddr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		// Requests in progress finish their asset, so allow as long as a model request may take.
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), OllamaTimeout)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	textf("Serving API on %s\n", ServeAddr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("Server error: %v", err)
+	}
+	s.scans.Wait()
+	textf("Server stopped.\n")
+	Logger.Info("server stopped")
+}
+
+func (s *server) handleDescribe(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		AssetID string `json:"assetId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.AssetID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": `expected {"assetId": "..."}`})
+		return
+	}
+	if !uuidPattern.MatchString(req.AssetID) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": fmt.Sprintf("invalid asset ID %q", req.AssetID)})
+		return
+	}
+	assets, missing, err := findAssets(r.Context(), s.proc.conn, []string{req.AssetID})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	if len(missing) > 0 {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": fmt.Sprintf("asset %s not found", req.AssetID)})
+		return
+	}
+	// Excluded tags are a privacy setting, so they apply to requests too.
+	excluded, err := excludedByTag(r.Context(), s.proc.conn, assets)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	if excluded[assets[0].ID] {
+		Logger.Info("asset excluded by tag", "asset_id", assets[0].ID)
+		writeJSON(w, http.StatusForbidden, map[string]any{"error": fmt.Sprintf("asset %s has an excluded tag (-exclude-tag)", assets[0].ID)})
+		return
+	}
+
+	job := assetJob{ID: assets[0].ID, Type: assets[0].Type, Count: 1, Total: 1}
+	prefix := "[API] Processing " + job.ID
+	start := time.Now()
+	res, stepErr := s.proc.process(job)
+	if stepErr != nil {
+		s.proc.skip(prefix, job.ID, start, stepErr)
+		writeJSON(w, http.StatusBadGateway, map[string]any{"error": stepErr.Error(), "reason": stepErr.reason})
+		return
+	}
+	s.proc.errors.resolve(job.ID)
+	status := "Done!"
+	if DryRun {
+		status = "Would save"
+	}
+	s.proc.printResult(prefix, status, res)
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"assetId":     res.ID,
+		"description": res.Desc,
+		"keywords":    res.Keywords,
+		"model":       res.Model,
+		"saved":       !DryRun,
+		"durationMs":  time.Since(res.Start).Milliseconds(),
+	})
+}
+
+func (s *server) handlePending(w http.ResponseWriter, r *http.Request) {
+	n, err := countPending(r.Context(), s.proc.conn)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"pending": n})
+}
+
+// handleRun starts a scan over all pending assets, unless one is already running.
+func (s *server) handleRun(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		writeJSON(w, http.StatusConflict, map[string]any{"error": "a run is already in progress"})
+		return
+	}
+	if s.proc.ctx.Err() != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"error": "shutting down"})
+		return
+	}
+	s.running = true
+	s.scans.Go(func() {
+		start := time.Now()
+		processed, stoppedEarly := s.proc.scanAll(false)
+		s.proc.printSummary(processed, time.Since(start), stoppedEarly)
+		s.mu.Lock()
+		s.running = false
+		s.mu.Unlock()
+	})
+	writeJSON(w, http.StatusAccepted, map[string]any{"started": true})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Sidecar selects the format of the sidecar files written for every saved
+// description (-sidecar json|xmp); empty writes none. The files go to
+// SidecarDir and are named after the asset's original file, e.g.
+// IMG_0001.JPG.xmp.
+var (
+	Sidecar    string
+	SidecarDir string
+)
+
+// sidecarMu serializes the file name collision check with the write.
+var sidecarMu sync.Mutex
+
+// sidecarJSON is the content of a JSON sidecar file.
+type sidecarJSON struct {
+	AssetID     string    `json:"assetId"`
+	FileName    string    `json:"fileName"`
+	Description string    `json:"description"`
+	Keywords    []string  `json:"keywords,omitempty"`
+	Model       string    `json:"model"`
+	GeneratedAt time.Time `json:"generatedAt"`
+}
+
+// writeSidecar writes the sidecar file for a saved description.
+func writeSidecar(ctx context.Context, conn *pgxpool.Pool, res assetResult) error {
+	var fileName string
+	if err := conn.QueryRow(ctx, `SELECT "originalFileName" FROM asset WHERE id = $1`, res.ID).Scan(&fileName); err != nil {
+		return err
+	}
+	fileName = strings.NewReplacer("/", "_", "\\", "_").Replace(fileName)
+	if fileName == "" || fileName == "." || fileName == ".." {
+		fileName = res.ID
+	}
+
+	desc, keywords := res.Desc, res.Keywords
+	if !ExtractTags {
+		// The keywords are still part of the description; list them separately.
+		desc, keywords = splitDescription(res.Desc)
+	}
+
+	var data []byte
+	if Sidecar == "xmp" {
+		data = sidecarXMP(res.ID, desc, keywords)
+	} else {
+		var err error
+		data, err = json.MarshalIndent(sidecarJSON{
+			AssetID:     res.ID,
+			FileName:    fileName,
+			Description: desc,
+			Keywords:    keywords,
+			Model:       res.Model,
+			GeneratedAt: time.Now().UTC(),
+		}, "", "  ")
+		if err != nil {
+			return err
+		}
+	}
+
+	tmp, err := os.CreateTemp(SidecarDir, ".sidecar-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0o644)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	// Choosing the name and moving the file there happen together, so two
+	// workers can't both claim the same name.
+	sidecarMu.Lock()
+	defer sidecarMu.Unlock()
+	path := filepath.Join(SidecarDir, fileName+"."+Sidecar)
+	if existing, err := os.ReadFile(path); err == nil && !bytes.Contains(existing, []byte(res.ID)) {
+		// Another asset with the same file name (e.g. IMG_0001.JPG from two cameras).
+		ext := filepath.Ext(fileName)
+		path = filepath.Join(SidecarDir, fmt.Sprintf("%s_%s%s.%s", strings.TrimSuffix(fileName, ext), res.ID, ext, Sidecar))
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// sidecarXMP returns an XMP packet with the description as dc:description,
+// the keywords as dc:subject and the asset ID as dc:identifier.
+func sidecarXMP(assetID, desc string, keywords []string) []byte {
+	esc := func(s string) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	var b strings.Builder
+	b.WriteString(`<?xpacket begin="` + "\uFEFF" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/">
+`)
+	fmt.Fprintf(&b, "   <dc:identifier>%s</dc:identifier>\n", esc(assetID))
+	fmt.Fprintf(&b, "   <dc:description><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:description>\n", esc(desc))
+	if len(keywords) > 0 {
+		b.WriteString("   <dc:subject><rdf:Bag>")
+		for _, k := range keywords {
+			fmt.Fprintf(&b, "<rdf:li>%s</rdf:li>", esc(k))
+		}
+		b.WriteString("</rdf:Bag></dc:subject>\n")
+	}
+	b.WriteString(`  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`)
+	return []byte(b.String())
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image/jpeg"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DescribeStacks describes each Immich stack as a whole (-describe-stacks):
+// up to StackImages of its shots are sent to the model in one request, and the
+// description is saved to the stack's primary asset only.
+var (
+	DescribeStacks bool
+	StackImages    int
+)
+
+// stackCond is the pendingFilter condition for -describe-stacks: stacked
+// assets other than the primary one are left alone.
+const stackCond = `(a."stackId" IS NULL OR a.id IN (SELECT s."primaryAssetId" FROM stack s))`
+
+// stackMembers returns up to limit other images in the asset's stack, in
+// capture order. It returns none for an asset that isn't stacked. Shots with an
+// -exclude-tag tag are left out, so they never reach the model.
+func stackMembers(ctx context.Context, conn *pgxpool.Pool, assetID string, limit int) ([]string, error) {
+	// The members are "a", as excludeTagCond expects.
+	f := &assetFilter{}
+	f.where(fmt.Sprintf("p.id = %s", f.arg(assetID)))
+	f.where(`a.id <> p.id AND a.type = 'IMAGE' AND a."deletedAt" IS NULL`)
+	if len(ExcludeTags) > 0 {
+		f.where(excludeTagCond(f))
+	}
+	query := fmt.Sprintf(`
+		SELECT a.id::text
+		FROM asset p
+		JOIN asset a ON a."stackId" = p."stackId"
+		%s
+		ORDER BY a."fileCreatedAt", a.id
+		LIMIT %s
+	`, f.sql(), f.arg(limit))
+	rows, err := conn.Query(ctx, query, f.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// fetchStackImages downloads and converts the other images of the asset's
+// stack. A shot that fails is left out rather than failing the stack.
+func (p *processor) fetchStackImages(ctx context.Context, assetID string) []string {
+	ids, err := stackMembers(ctx, p.conn, assetID, StackImages-1)
+	if err != nil {
+		Logger.Warn("stack lookup failed", "asset_id", assetID, "error", err.Error())
+		return nil
+	}
+	var images []string
+	for _, id := range ids {
+		data, err := downloadThumbnail(ctx, id)
+		if err == nil && !keepWebP(data, MaxDimension) {
+			data, err = ensureJPEG(data, &jpeg.Options{Quality: JPEGQuality}, MaxDimension)
+		}
+		if err != nil {
+			Logger.Warn("stack image skipped", "asset_id", assetID, "stacked_asset_id", id, "error", err.Error())
+			continue
+		}
+		images = append(images, base64.StdEncoding.EncodeToString(data))
+	}
+	return images
+}
+
+// stackPrompt tells the model that the n images are shots of one stack.
+func stackPrompt(n int) string {
+	return fmt.Sprintf("The following %d images are shots of the same scene. Write a single description that covers all of them.\n\n", n)
+}
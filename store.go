@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DBRetries is the number of times a failed description write is retried
+// before the asset is skipped.
+var DBRetries int
+
+// DBReconnectAttempts is how often watch mode tries to reconnect to an
+// unreachable database before giving up.
+var DBReconnectAttempts int
+
+// metaTable records which model generated each description (see -track-model).
+// It lives next to Immich's own tables and is removed with the asset.
+const metaTable = "immich_analyze_meta"
+
+// ensureMetaTable creates metaTable if it doesn't exist yet.
+func ensureMetaTable(ctx context.Context, conn *pgxpool.Pool) error {
+	_, err := conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS `+metaTable+` (
+			"assetId"     uuid PRIMARY KEY REFERENCES asset(id) ON DELETE CASCADE,
+			model         text NOT NULL,
+			"generatedAt" timestamptz NOT NULL DEFAULT now(),
+			"thumbnailHash" text,
+			"perceptualHash" bigint
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	// Tables created by older versions lack the hash columns.
+	_, err = conn.Exec(ctx, `ALTER TABLE `+metaTable+` ADD COLUMN IF NOT EXISTS "thumbnailHash" text, ADD COLUMN IF NOT EXISTS "perceptualHash" bigint`)
+	return err
+}
+
+// storedHash returns the thumbnail hash recorded when the asset was last
+// described, or "" if there is none.
+func storedHash(ctx context.Context, conn *pgxpool.Pool, assetID string) (string, error) {
+	var hash string
+	err := conn.QueryRow(ctx, `SELECT COALESCE("thumbnailHash", '') FROM `+metaTable+` WHERE "assetId" = $1`, assetID).Scan(&hash)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return hash, err
+}
+
+// metaTableExists reports whether metaTable has been created by an earlier run.
+func metaTableExists(ctx context.Context, conn *pgxpool.Pool) (bool, error) {
+	var ok bool
+	err := conn.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL`, metaTable).Scan(&ok)
+	return ok, err
+}
+
+// WriteVia selects how descriptions are saved (-write-via): "sql" updates
+// Immich's database directly, "api" goes through the Immich API like an edit
+// in the web UI, so Immich updates what it derives from the description (e.g.
+// its XMP sidecars) itself. -source api always writes through the API.
+var WriteVia string
+
+// writeViaAPI reports whether descriptions are saved through the Immich API.
+func writeViaAPI() bool {
+	return Source == "api" || WriteVia == "api"
+}
+
+// descriptionWrite is one description to save.
+type descriptionWrite struct {
+	AssetID string
+	Desc    string
+	Model   string
+	Hash    string // SHA-256 of the thumbnail the description was generated from
+	PHash   *int64 // its perceptual hash, with -phash-reuse-threshold
+}
+
+// saveDescription writes the description to the asset and, with -track-model,
+// records the generating model and thumbnail hash in the same transaction.
+func saveDescription(ctx context.Context, conn *pgxpool.Pool, w descriptionWrite) error {
+	if !TrackModel && !writeViaAPI() {
+		return withDBRetry(ctx, conn, func() error {
+			_, err := conn.Exec(ctx, target.update(), w.Desc, w.AssetID)
+			return err
+		})
+	}
+	return saveDescriptions(ctx, conn, []descriptionWrite{w})
+}
+
+// saveDescriptions writes several descriptions in a single transaction, sent
+// to the database in one round trip. With -write-via api they are sent to
+// Immich one by one instead, and only the model tracking goes to the database.
+func saveDescriptions(ctx context.Context, conn *pgxpool.Pool, writes []descriptionWrite) error {
+	if writeViaAPI() {
+		if err := apiSaveDescriptions(ctx, writes); err != nil || !TrackModel {
+			return err
+		}
+	}
+	return withDBRetry(ctx, conn, func() error {
+		return writeDescriptions(ctx, conn, writes)
+	})
+}
+
+func writeDescriptions(ctx context.Context, conn *pgxpool.Pool, writes []descriptionWrite) error {
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		b := &pgx.Batch{}
+		for _, w := range writes {
+			if !writeViaAPI() {
+				b.Queue(target.update(), w.Desc, w.AssetID)
+			}
+			if TrackModel {
+				b.Queue(`
+					INSERT INTO `+metaTable+` ("assetId", model, "generatedAt", "thumbnailHash", "perceptualHash")
+					VALUES ($1, $2, now(), NULLIF($3, ''), $4)
+					ON CONFLICT ("assetId") DO UPDATE SET model = EXCLUDED.model, "generatedAt" = EXCLUDED."generatedAt",
+						"thumbnailHash" = EXCLUDED."thumbnailHash", "perceptualHash" = EXCLUDED."perceptualHash"
+				`, w.AssetID, w.Model, w.Hash, w.PHash)
+			}
+		}
+		return tx.SendBatch(ctx, b).Close()
+	})
+}
+
+// withDBRetry runs write, retrying with backoff while the database is
+// unreachable (restart, failover). Before the last attempt all pooled
+// connections are dropped so it starts from fresh ones. Errors reported by
+// Postgres itself, such as constraint violations, are not retried.
+func withDBRetry(ctx context.Context, conn *pgxpool.Pool, write func() error) error {
+	policy := RetryPolicy{MaxAttempts: DBRetries + 1, BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+	var err error
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		if err = write(); err == nil || !dbTransient(err) || attempt == policy.MaxAttempts {
+			return err
+		}
+		delay := policy.backoff(attempt)
+		textf("   [DB RETRY] write failed (%v), retrying in %v\n", err, delay.Round(time.Millisecond))
+		Logger.Warn("db write failed, retrying", "attempt", attempt, "max_attempts", policy.MaxAttempts, "delay", delay.String(), "error", err.Error())
+		sleepCtx(ctx, delay)
+		if attempt+1 == policy.MaxAttempts {
+			conn.Reset()
+		}
+	}
+	return err
+}
+
+// waitForDB checks that the database is reachable and otherwise reconnects
+// with exponential backoff (up to 5 minutes between attempts). It returns the
+// last error if the database is still unreachable after DBReconnectAttempts.
+func waitForDB(ctx context.Context, conn *pgxpool.Pool) error {
+	policy := RetryPolicy{MaxAttempts: DBReconnectAttempts, BaseDelay: time.Second, MaxDelay: 5 * time.Minute}
+	err := conn.Ping(ctx)
+	if err == nil {
+		return nil
+	}
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		delay := policy.backoff(attempt)
+		textf("[DB] Database unreachable (%v), reconnecting in %v (attempt %d/%d)\n", err, delay.Round(time.Second), attempt, policy.MaxAttempts)
+		Logger.Warn("database unreachable, reconnecting", "attempt", attempt, "max_attempts", policy.MaxAttempts, "delay", delay.String(), "error", err.Error())
+		sleepCtx(ctx, delay)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// Drop every pooled connection: after a restart they are all dead.
+		conn.Reset()
+		if err = conn.Ping(ctx); err == nil {
+			textf("[DB] Reconnected\n")
+			Logger.Info("database reconnected", "attempts", attempt)
+			return nil
+		}
+	}
+	return err
+}
+
+// assetGone reports whether a write failed because the asset no longer
+// exists: a foreign key violation in the database, or a 400 or 404 from the
+// Immich API.
+func assetGone(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23503"
+	}
+	var ie *immichError
+	return errors.As(err, &ie) && (ie.Status == http.StatusNotFound || ie.Status == http.StatusBadRequest)
+}
+
+// dbTransient reports whether err looks like a connection problem rather than
+// a problem with the statement.
+func dbTransient(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// Class 08: connection exception; 57P01-57P03: server shutting down or starting up.
+		return strings.HasPrefix(pgErr.Code, "08") || pgErr.Code == "57P01" || pgErr.Code == "57P02" || pgErr.Code == "57P03"
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	// Without a Postgres error code, only failures of the connection itself
+	// count; anything else (e.g. a value pgx can't encode) fails again.
+	var netErr net.Error
+	return errors.As(err, &netErr) || pgconn.SafeToRetry(err) || pgconn.Timeout(err) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestDBTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection exception", &pgconn.PgError{Code: "08006"}, true},
+		{"admin shutdown", &pgconn.PgError{Code: "57P01"}, true},
+		{"unique violation", &pgconn.PgError{Code: "23505"}, false},
+		{"dial error", fmt.Errorf("save: %w", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}), true},
+		{"connection closed", fmt.Errorf("save: %w", io.ErrUnexpectedEOF), true},
+		{"timeout", context.DeadlineExceeded, true},
+		{"canceled", context.Canceled, false},
+		{"encoding error", errors.New("unable to encode 1.5 into binary format for int8"), false},
+		{"immich error", &immichError{Status: 500}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dbTransient(tt.err); got != tt.want {
+				t.Errorf("dbTransient(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
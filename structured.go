@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Structured asks the model for a JSON object with the description and the
+// keywords as separate fields (-structured), instead of parsing them out of
+// free text. Ollama is put into JSON mode; other backends only get the
+// instruction in the prompt. Output that isn't valid JSON is parsed as text.
+var Structured bool
+
+// JSONSchema constrains the output with structuredSchema instead of plain JSON
+// mode (-json-schema, implies -structured). Responses are checked against the
+// schema, and one that doesn't match is retried once with a reminder.
+var JSONSchema bool
+
+// structuredSchema is the JSON schema of structuredOutput, sent as Ollama's
+// format and as the OpenAI response_format.
+var structuredSchema = json.RawMessage(`{
+	"type": "object",
+	"properties": {
+		"description": {"type": "string"},
+		"keywords": {"type": "array", "items": {"type": "string"}}
+	},
+	"required": ["description", "keywords"]
+}`)
+
+// structuredInstruction is appended to the prompt with -structured.
+const structuredInstruction = ` Respond with only a JSON object of the form {"description": "...", "keywords": ["...", "..."]}.`
+
+// structuredOutput is the JSON object requested with -structured.
+type structuredOutput struct {
+	Description string   `json:"description"`
+	Keywords    []string `json:"keywords"`
+}
+
+// parseStructured decodes a -structured response. Text around the object,
+// such as a markdown fence, is ignored. It reports false if there is no
+// object with a non-empty description.
+func parseStructured(text string) (structuredOutput, bool) {
+	start, end := strings.IndexByte(text, '{'), strings.LastIndexByte(text, '}')
+	if start < 0 || end < start {
+		return structuredOutput{}, false
+	}
+	var out structuredOutput
+	if err := json.Unmarshal([]byte(text[start:end+1]), &out); err != nil {
+		return structuredOutput{}, false
+	}
+	out.Description = strings.TrimSpace(out.Description)
+	out.Keywords = parseKeywords(out.Keywords)
+	return out, out.Description != ""
+}
+
+// splitOutput separates the cleaned model output into the description to save
+// and, with -extract-tags, the keywords to tag the asset with. Without
+// -extract-tags, structured keywords are kept in the description like the
+// default prompt's "Keywords:" list. It reports false if a -structured
+// response couldn't be parsed and was split as text instead.
+func splitOutput(text string) (string, []string, bool) {
+	if Structured {
+		if out, ok := parseStructured(text); ok {
+			if ExtractTags {
+				return out.Description, out.Keywords, true
+			}
+			if len(out.Keywords) == 0 {
+				return out.Description, nil, true
+			}
+			return out.Description + "\n\nKeywords: " + strings.Join(out.Keywords, ", "), nil, true
+		}
+	}
+	if ExtractTags {
+		desc, keywords := splitDescription(text)
+		return desc, keywords, !Structured
+	}
+	return text, nil, !Structured
+}
+
+// checkStructured verifies that a response matches structuredSchema, with a
+// non-empty description.
+func checkStructured(text string) error {
+	start, end := strings.IndexByte(text, '{'), strings.LastIndexByte(text, '}')
+	if start < 0 || end < start {
+		return errors.New("no JSON object")
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(text[start:end+1]), &fields); err != nil {
+		return fmt.Errorf("malformed JSON: %v", err)
+	}
+	var desc string
+	if raw, ok := fields["description"]; !ok {
+		return errors.New(`missing "description"`)
+	} else if json.Unmarshal(raw, &desc) != nil {
+		return errors.New(`"description" is not a string`)
+	} else if strings.TrimSpace(desc) == "" {
+		return errors.New(`"description" is empty`)
+	}
+	var keywords []string
+	if raw, ok := fields["keywords"]; !ok {
+		return errors.New(`missing "keywords"`)
+	} else if json.Unmarshal(raw, &keywords) != nil {
+		return errors.New(`"keywords" is not an array of strings`)
+	}
+	return nil
+}
+
+// errEmptyResponse means the model answered with nothing but whitespace, even
+// when asked again.
+var errEmptyResponse = errors.New("empty response")
+
+// describeChecked is describeWithFallback that asks the model that answered
+// once more if its response is empty or, with -json-schema, doesn't match the
+// schema.
+func describeChecked(ctx context.Context, describer Describer, prompt string, images []string, models []string, policy RetryPolicy) (string, string, error) {
+	desc, model, err := describeWithFallback(ctx, describer, prompt, images, models, policy)
+	if err != nil {
+		return "", model, err
+	}
+	if strings.TrimSpace(desc) == "" {
+		Logger.Warn("empty response, retrying", "model", model)
+		nudge := "\n\nYour previous answer was empty. Look at the image again and describe what you see."
+		if desc, err = generateDescription(ctx, describer, prompt+nudge, images, model, policy); err != nil {
+			return "", model, err
+		}
+		if strings.TrimSpace(desc) == "" {
+			return "", model, errEmptyResponse
+		}
+	}
+	if !JSONSchema {
+		return desc, model, nil
+	}
+	invalid := checkStructured(desc)
+	if invalid == nil {
+		return desc, model, nil
+	}
+	Logger.Warn("response does not match the JSON schema, retrying", "model", model, "error", invalid.Error())
+	reminder := fmt.Sprintf("\n\nYour previous answer was not valid (%v). Respond with nothing but the JSON object, with a non-empty \"description\" string and a \"keywords\" array of strings.", invalid)
+	if desc, err = generateDescription(ctx, describer, prompt+reminder, images, model, policy); err != nil {
+		return "", model, err
+	}
+	if invalid = checkStructured(desc); invalid != nil {
+		return "", model, fmt.Errorf("response does not match the JSON schema: %v", invalid)
+	}
+	return desc, model, nil
+}
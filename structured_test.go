@@ -0,0 +1,39 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseStructured(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		desc     string
+		keywords []string
+		ok       bool
+	}{
+		{"plain", `{"description": "A dog.", "keywords": ["dog", "beach"]}`, "A dog.", []string{"dog", "beach"}, true},
+		{"fenced", "```json\n{\"description\": \" A dog. \", \"keywords\": [\"dog\"]}\n```", "A dog.", []string{"dog"}, true},
+		{"no keywords", `{"description": "A dog."}`, "A dog.", nil, true},
+		{"keywords cleaned", `{"description": "A dog.", "keywords": ["Dog", "dog", "", "black/white"]}`, "A dog.", []string{"Dog", "black-white"}, true},
+		{"empty description", `{"description": "  ", "keywords": ["dog"]}`, "", nil, false},
+		{"not json", "A dog on a beach.", "", nil, false},
+		{"invalid json", `{"description": "A dog.",}`, "", nil, false},
+		{"braces reversed", "} {", "", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, ok := parseStructured(tt.in)
+			if ok != tt.ok {
+				t.Fatalf("parseStructured(%q) ok = %v, want %v", tt.in, ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if out.Description != tt.desc || !slices.Equal(out.Keywords, tt.keywords) {
+				t.Errorf("parseStructured(%q) = %q, %q, want %q, %q", tt.in, out.Description, out.Keywords, tt.desc, tt.keywords)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type TagResponse struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+var (
+	keywordsHeader = regexp.MustCompile(`(?i)^\W*(?:\d+\s+)?(?:relevant\s+)?(?:search\s+)?(?:keywords|tags)\b(?:[^:]{0,30}:|\W*$)[*_\s]*`)
+	listMarker     = regexp.MustCompile(`^\s*(?:[-*•]+|\d+[.)])\s+`)
+)
+
+// splitDescription separates the model output into the description text and
+// the keyword list that the default prompt asks for. The keywords are either
+// introduced by a "Keywords:" style header or given as a trailing bullet list.
+// If no keywords are found the whole text is returned as the description.
+func splitDescription(text string) (string, []string) {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+
+	cut := -1
+	for i, line := range lines {
+		if keywordsHeader.MatchString(strings.TrimSpace(line)) {
+			cut = i
+			break
+		}
+	}
+	var rest []string
+	if cut >= 0 {
+		rest = append([]string{keywordsHeader.ReplaceAllString(strings.TrimSpace(lines[cut]), "")}, lines[cut+1:]...)
+	} else {
+		// No header: treat a trailing run of list items as the keywords.
+		cut = len(lines)
+		for cut > 0 && listMarker.MatchString(lines[cut-1]) {
+			cut--
+		}
+		if cut == len(lines) || cut == 0 {
+			return strings.TrimSpace(text), nil
+		}
+		rest = lines[cut:]
+	}
+
+	desc := strings.TrimSpace(strings.Join(lines[:cut], "\n"))
+	return desc, parseKeywords(rest)
+}
+
+func parseKeywords(lines []string) []string {
+	seen := make(map[string]bool)
+	var keywords []string
+	for _, line := range lines {
+		line = listMarker.ReplaceAllString(line, "")
+		for _, kw := range strings.Split(line, ",") {
+			kw = strings.Trim(strings.TrimSpace(kw), "*_`\"'.")
+			// "/" creates nested tags in Immich, which is never what the model means.
+			kw = strings.ReplaceAll(kw, "/", "-")
+			if kw == "" || len(kw) > 50 {
+				continue
+			}
+			key := strings.ToLower(kw)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			keywords = append(keywords, kw)
+		}
+	}
+	return keywords
+}
+
+// applyTags creates any missing Immich tags for keywords and attaches them to the asset.
+func applyTags(ctx context.Context, assetID string, keywords []string) error {
+	if len(keywords) == 0 {
+		return nil
+	}
+	var tags []TagResponse
+	if err := immichJSON(ctx, "PUT", "/api/tags", map[string]any{"tags": keywords}, &tags); err != nil {
+		return fmt.Errorf("upsert tags: %v", err)
+	}
+	tagIDs := make([]string, 0, len(tags))
+	for _, t := range tags {
+		tagIDs = append(tagIDs, t.ID)
+	}
+	body := map[string]any{"tagIds": tagIDs, "assetIds": []string{assetID}}
+	if err := immichJSON(ctx, "PUT", "/api/tags/assets", body, nil); err != nil {
+		return fmt.Errorf("tag asset: %v", err)
+	}
+	return nil
+}
+
+// immichError is an error response from the Immich API.
+type immichError struct {
+	Status  int
+	Message string
+}
+
+func (e *immichError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.Status, e.Message)
+}
+
+// immichJSON sends a JSON request to the Immich API and decodes the response into out (if non-nil).
+func immichJSON(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, ImmichBaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	setImmichAuth(req)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{Timeout: 15 * time.Second, Transport: httpTransport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(resp.Body)
+		return &immichError{Status: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
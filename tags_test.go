@@ -0,0 +1,32 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitDescription(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		desc     string
+		keywords []string
+	}{
+		{"no keywords", "A dog on a beach.", "A dog on a beach.", nil},
+		{"header", "A dog on a beach.\n\nKeywords: dog, beach, sand", "A dog on a beach.", []string{"dog", "beach", "sand"}},
+		{"markdown header with list", "A dog on a beach.\n\n**Tags:**\n- dog\n- beach", "A dog on a beach.", []string{"dog", "beach"}},
+		{"trailing list", "A dog on a beach.\n- dog\n- beach", "A dog on a beach.", []string{"dog", "beach"}},
+		{"duplicates and slashes", "A dog.\nKeywords: Dog, dog, black/white", "A dog.", []string{"Dog", "black-white"}},
+		{"empty keyword line", "A dog on a beach.\nKeywords: dog,\n\n, beach", "A dog on a beach.", []string{"dog", "beach"}},
+		{"header without values", "A dog on a beach.\nKeywords:", "A dog on a beach.", nil},
+		{"only list", "- dog\n- beach", "- dog\n- beach", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			desc, keywords := splitDescription(tt.in)
+			if desc != tt.desc || !slices.Equal(keywords, tt.keywords) {
+				t.Errorf("splitDescription(%q) = %q, %q, want %q, %q", tt.in, desc, keywords, tt.desc, tt.keywords)
+			}
+		})
+	}
+}
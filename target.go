@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TargetColumn is where descriptions are written (-target-column): a column
+// of asset_exif ("description", Immich's own field, by default), or
+// "table.column" for a separate table keyed by "assetId".
+var TargetColumn string
+
+// target is TargetColumn, parsed at startup.
+var target = descriptionTarget{column: "description"}
+
+type descriptionTarget struct {
+	table  string // "" for asset_exif
+	column string
+}
+
+var sqlName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseTarget parses a -target-column value.
+func parseTarget(s string) (descriptionTarget, error) {
+	table, column, separate := strings.Cut(s, ".")
+	if !separate {
+		table, column = "", s
+	}
+	for _, name := range []string{table, column} {
+		if name != "" && !sqlName.MatchString(name) {
+			return descriptionTarget{}, fmt.Errorf("invalid -target-column %q (use column or table.column, letters, digits and '_')", s)
+		}
+	}
+	if column == "" || separate && table == "" {
+		return descriptionTarget{}, fmt.Errorf("invalid -target-column %q (use column or table.column)", s)
+	}
+	if table == "asset_exif" {
+		table = ""
+	}
+	if table == "asset" || table == metaTable {
+		return descriptionTarget{}, fmt.Errorf("invalid -target-column %q: %s is not a description table", s, table)
+	}
+	return descriptionTarget{table: table, column: column}, nil
+}
+
+func (t descriptionTarget) String() string {
+	if t.table == "" {
+		return "asset_exif." + t.column
+	}
+	return t.table + "." + t.column
+}
+
+// native reports whether descriptions go to Immich's own description field.
+func (t descriptionTarget) native() bool {
+	return t.table == "" && t.column == "description"
+}
+
+// value returns an SQL expression for the current description of asset a
+// (left joined with its asset_exif row ae), NULL if there is none.
+func (t descriptionTarget) value() string {
+	col := pgx.Identifier{t.column}.Sanitize()
+	if t.table == "" {
+		return "ae." + col
+	}
+	return fmt.Sprintf(`(SELECT t.%s FROM %s t WHERE t."assetId" = a.id)`, col, pgx.Identifier{t.table}.Sanitize())
+}
+
+// update returns the statement that stores description $1 for asset $2. It
+// is an upsert for asset_exif too, like Immich's own description edits: some
+// imports have no asset_exif row, and an UPDATE would report success without
+// saving anything.
+func (t descriptionTarget) update() string {
+	table := t.table
+	if table == "" {
+		table = "asset_exif"
+	}
+	col := pgx.Identifier{t.column}.Sanitize()
+	return fmt.Sprintf(`
+		INSERT INTO %s ("assetId", %s) VALUES ($2, $1)
+		ON CONFLICT ("assetId") DO UPDATE SET %s = EXCLUDED.%s
+	`, pgx.Identifier{table}.Sanitize(), col, col, col)
+}
+
+// prepare makes sure the target exists. With create, a separate table is
+// created (or given the column) if needed; columns of asset_exif are never
+// added, since that table belongs to Immich.
+func (t descriptionTarget) prepare(ctx context.Context, conn *pgxpool.Pool, create bool) error {
+	if t.table != "" && create {
+		table, col := pgx.Identifier{t.table}.Sanitize(), pgx.Identifier{t.column}.Sanitize()
+		if _, err := conn.Exec(ctx, `
+			CREATE TABLE IF NOT EXISTS `+table+` (
+				"assetId" uuid PRIMARY KEY REFERENCES asset(id) ON DELETE CASCADE,
+				`+col+` text
+			)
+		`); err != nil {
+			return err
+		}
+		_, err := conn.Exec(ctx, `ALTER TABLE `+table+` ADD COLUMN IF NOT EXISTS `+col+` text`)
+		return err
+	}
+
+	table := t.table
+	if table == "" {
+		table = "asset_exif"
+	}
+	var ok bool
+	err := conn.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2)
+	`, table, t.column).Scan(&ok)
+	switch {
+	case err != nil:
+		return err
+	case ok:
+		return nil
+	case t.table == "":
+		return fmt.Errorf("asset_exif has no column %q (to use a table of your own, give -target-column as table.column)", t.column)
+	default:
+		return fmt.Errorf("%s does not exist yet (it is created by the first run without -dry-run)", t)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"description", "asset_exif.description", false},
+		{"ai_description", "asset_exif.ai_description", false},
+		{"asset_exif.description", "asset_exif.description", false},
+		{"ai_captions.text", "ai_captions.text", false},
+		{"", "", true},
+		{".description", "", true},
+		{"ai_captions.", "", true},
+		{"ai captions.text", "", true},
+		{"ai_captions.text;drop", "", true},
+		{"1table.text", "", true},
+		{"a.b.c", "", true},
+		{"asset.description", "", true},
+		{metaTable + ".model", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseTarget(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseTarget(%q) = %s, want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTarget(%q): %v", tt.in, err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("parseTarget(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// MaxChars caps the length of a saved description (0 = no limit).
+var MaxChars int
+
+// MinChars is the length below which a description is rejected as useless
+// (e.g. "A photo."), so the asset is retried later instead (0 = no minimum).
+var MinChars int
+
+// StripPreamble removes chat-style lead-ins and markdown fences from the
+// model output (-strip-preamble).
+var StripPreamble bool
+
+// OutputRegex, if set, selects the part of the model output to keep: its
+// first capture group, or the whole match if it has none (-output-regex).
+var OutputRegex *regexp.Regexp
+
+// preamblePattern matches lead-ins like "Sure! Here is a description of the image:"
+// or a lone "Sure!" line. The "here is" part must mention the description or
+// image, so "Here are two dogs playing: one black, one white" is kept.
+var preamblePattern = regexp.MustCompile(`(?i)^(?:(?:(?:sure|certainly|of course|absolutely|okay|ok|alright)[!.,]*\s+)?here(?:'s| is| are)\b[^:\n]{0,80}\b(?:description|caption|image|photo|picture)[^:\n]{0,40}:\s*|(?:sure|certainly|of course|absolutely|okay|ok|alright)[!.,]+[ \t]*\n\s*)`)
+
+// cleanOutput applies -strip-preamble and -output-regex to a model response.
+// It reports false if -output-regex didn't match, in which case the text is
+// returned unchanged apart from the preamble.
+func cleanOutput(s string) (string, bool) {
+	if StripPreamble {
+		s = stripPreamble(s)
+	}
+	if OutputRegex == nil {
+		return s, true
+	}
+	m := OutputRegex.FindStringSubmatch(s)
+	if m == nil {
+		return s, false
+	}
+	if len(m) > 1 {
+		return strings.TrimSpace(m[1]), true
+	}
+	return strings.TrimSpace(m[0]), true
+}
+
+// stripPreamble removes a leading assistant phrase and a surrounding markdown
+// code fence.
+func stripPreamble(s string) string {
+	s = strings.TrimSpace(s)
+	for range 2 { // the preamble may come before or inside the fence
+		if rest, ok := strings.CutPrefix(s, "```"); ok {
+			// Drop the language tag, if any, and the closing fence.
+			if i := strings.IndexByte(rest, '\n'); i >= 0 {
+				rest = rest[i+1:]
+			}
+			s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(rest), "```"))
+		}
+		if loc := preamblePattern.FindStringIndex(s); loc != nil && loc[1] > 0 && loc[1] < len(s) {
+			s = strings.TrimSpace(s[loc[1]:])
+		}
+	}
+	return s
+}
+
+// sanitizeText makes model output safe to store in Postgres, which rejects
+// invalid UTF-8 and null bytes in text columns: invalid byte sequences, null
+// bytes and control characters other than newlines and tabs are removed. It
+// reports whether anything was removed.
+func sanitizeText(s string) (string, bool) {
+	clean := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(s, ""))
+	return clean, clean != s
+}
+
+// truncateAtWord shortens s to at most max characters, cutting at the last
+// word boundary and ending with "…". It reports whether s was shortened.
+func truncateAtWord(s string, max int) (string, bool) {
+	r := []rune(s)
+	if max <= 0 || len(r) <= max {
+		return s, false
+	}
+	cut := r[:max-1] // leave room for the ellipsis
+	if i := strings.LastIndexFunc(string(cut), unicode.IsSpace); i > 0 {
+		cut = []rune(string(cut)[:i])
+	}
+	return strings.TrimRightFunc(string(cut), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	}) + "…", true
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestStripPreamble(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"none", "A dog on a beach.", "A dog on a beach."},
+		{"interjection and here is", "Sure! Here is a description of the image:\nA dog on a beach.", "A dog on a beach."},
+		{"here is alone", "Here's a detailed description of the photo: A dog on a beach.", "A dog on a beach."},
+		{"interjection line", "Okay.\nA dog on a beach.", "A dog on a beach."},
+		{"fence", "```text\nA dog on a beach.\n```", "A dog on a beach."},
+		{"preamble before fence", "Certainly! Here is the image description:\n```\nA dog on a beach.\n```", "A dog on a beach."},
+		{"preamble inside fence", "```\nSure, here is the caption:\nA dog on a beach.\n```", "A dog on a beach."},
+		{"word starting with sure", "Sure-footed goats climb a rocky slope.", "Sure-footed goats climb a rocky slope."},
+		{"interjection mid sentence", "Okay, so this is a dog.", "Okay, so this is a dog."},
+		{"here are with content", "Here are two dogs playing: one black, one white.", "Here are two dogs playing: one black, one white."},
+		{"preamble only", "Sure! Here is a description of the image:", "Sure! Here is a description of the image:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripPreamble(tt.in); got != tt.want {
+				t.Errorf("stripPreamble(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
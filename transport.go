@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ProxyURL, if set, is used for all outgoing HTTP requests instead of the
+// proxy from HTTP_PROXY / HTTPS_PROXY / NO_PROXY.
+var ProxyURL string
+
+// InsecureTLS disables certificate verification for HTTPS requests.
+var InsecureTLS bool
+
+// CACertFile is a PEM bundle of extra CAs trusted for HTTPS requests, e.g. for
+// a reverse proxy with a self-signed certificate.
+var CACertFile string
+
+// ImmichAuthHeader ("Name: value") and ImmichBasicAuth ("user:password") are
+// sent with every Immich API request in addition to the API key, for Immich
+// behind an authenticating reverse proxy.
+var (
+	ImmichAuthHeader string
+	ImmichBasicAuth  string
+)
+
+// immichHeader is ImmichAuthHeader, split by setupTransport.
+var immichHeader [2]string
+
+// setImmichAuth adds the API key and the proxy credentials to an Immich request.
+func setImmichAuth(req *http.Request) {
+	req.Header.Set("x-api-key", ImmichAPIKey)
+	if immichHeader[0] != "" {
+		req.Header.Set(immichHeader[0], immichHeader[1])
+	}
+	if user, pass, ok := strings.Cut(ImmichBasicAuth, ":"); ok {
+		req.SetBasicAuth(user, pass)
+	}
+}
+
+// httpTransport is shared by every HTTP client (Immich and the model backend).
+// It also handles Ollama on a Unix socket (-ollama unix:///path).
+var httpTransport http.RoundTripper = http.DefaultTransport
+
+// setupTransport builds httpTransport from the proxy and TLS settings.
+func setupTransport() error {
+	if ImmichAuthHeader != "" {
+		name, value, ok := strings.Cut(ImmichAuthHeader, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return fmt.Errorf("invalid -immich-auth-header (expected \"Name: value\", e.g. \"Authorization: Bearer <token>\")")
+		}
+		immichHeader = [2]string{name, value}
+	}
+	if ImmichBasicAuth != "" {
+		if !strings.Contains(ImmichBasicAuth, ":") {
+			return fmt.Errorf("invalid -immich-basic-auth (expected user:password)")
+		}
+		if strings.EqualFold(immichHeader[0], "Authorization") {
+			return fmt.Errorf("-immich-basic-auth and an Authorization -immich-auth-header can't be combined")
+		}
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if ProxyURL != "" {
+		u, err := url.Parse(ProxyURL)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid -proxy: %q (expected e.g. http://proxy:3128)", ProxyURL)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	if InsecureTLS || CACertFile != "" {
+		t.TLSClientConfig = &tls.Config{InsecureSkipVerify: InsecureTLS}
+	}
+	if CACertFile != "" {
+		pem, err := os.ReadFile(CACertFile)
+		if err != nil {
+			return fmt.Errorf("failed to read -ca-cert: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in -ca-cert %s", CACertFile)
+		}
+		t.TLSClientConfig.RootCAs = pool
+	}
+	if path, ok := strings.CutPrefix(OllamaHost, "unix://"); ok {
+		if path == "" {
+			return fmt.Errorf("invalid -ollama: %q (expected e.g. unix:///run/ollama/ollama.sock)", OllamaHost)
+		}
+		dialUnixSocket(t, path)
+	}
+	httpTransport = t
+	return nil
+}
+
+// ollamaSocketHost stands in for the host name in requests to an Ollama
+// listening on a Unix socket; the transport dials the socket instead.
+const ollamaSocketHost = "ollama-unix-socket"
+
+// dialUnixSocket makes t connect to the Unix socket at path for requests to
+// ollamaSocketHost, and points OllamaHost there. Other hosts are unaffected.
+func dialUnixSocket(t *http.Transport, path string) {
+	OllamaHost = "http://" + ollamaSocketHost
+	dial := t.DialContext
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == ollamaSocketHost+":80" {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		}
+		return dial(ctx, network, addr)
+	}
+	proxy := t.Proxy
+	t.Proxy = func(req *http.Request) (*url.URL, error) {
+		if req.URL.Host == ollamaSocketHost || proxy == nil {
+			return nil, nil
+		}
+		return proxy(req)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WebhookURL receives a JSON summary when a run, or a watch cycle that did
+// work, completes; empty disables it.
+var WebhookURL string
+
+// DiscordWebhookURL and SlackWebhookURL receive the same summary formatted as
+// a chat message (-notify-discord, -notify-slack).
+var DiscordWebhookURL, SlackWebhookURL string
+
+func notificationsEnabled() bool {
+	return WebhookURL != "" || DiscordWebhookURL != "" || SlackWebhookURL != ""
+}
+
+// webhookPayload is the JSON body posted to WebhookURL.
+type webhookPayload struct {
+	Event           string    `json:"event"` // "run_complete" or "cycle_complete"
+	Processed       int       `json:"processed"`
+	Failed          int       `json:"failed"`
+	Pending         *int64    `json:"pending,omitempty"` // backlog left, if it could be counted
+	DurationSeconds float64   `json:"durationSeconds"`
+	Model           string    `json:"model"`
+	DryRun          bool      `json:"dryRun"`
+	StoppedEarly    bool      `json:"stoppedEarly"`
+	Timestamp       time.Time `json:"timestamp"`
+}
+
+// sendWebhook posts payload to every configured webhook. Failures are logged,
+// never fatal: a broken notification must not stop the run.
+func sendWebhook(ctx context.Context, payload webhookPayload) {
+	payload.Model = Models[0]
+	payload.DryRun = DryRun
+	payload.Timestamp = time.Now().UTC()
+	targets := []struct {
+		name, url string
+		body      any
+	}{
+		{"webhook", WebhookURL, payload},
+		{"discord", DiscordWebhookURL, discordMessage(payload)},
+		{"slack", SlackWebhookURL, slackMessage(payload)},
+	}
+	for _, t := range targets {
+		if t.url == "" {
+			continue
+		}
+		if err := postJSON(ctx, t.url, t.body); err != nil {
+			textf("[WARN] %s notification failed: %v\n", t.name, err)
+			Logger.Warn("notification failed", "target", t.name, "event", payload.Event, "error", err.Error())
+			continue
+		}
+		Logger.Debug("notification sent", "target", t.name, "event", payload.Event)
+	}
+}
+
+// summaryFields returns the summary as label/value pairs for chat messages.
+func (p webhookPayload) summaryFields() [][2]string {
+	fields := [][2]string{
+		{"Processed", fmt.Sprint(p.Processed)},
+		{"Failed", fmt.Sprint(p.Failed)},
+	}
+	if p.Pending != nil {
+		fields = append(fields, [2]string{"Backlog remaining", fmt.Sprint(*p.Pending)})
+	}
+	fields = append(fields,
+		[2]string{"Duration", (time.Duration(p.DurationSeconds) * time.Second).String()},
+		[2]string{"Model", p.Model},
+	)
+	return fields
+}
+
+func (p webhookPayload) title() string {
+	title := "Immich AI Tagger: run complete"
+	if p.Event == "cycle_complete" {
+		title = "Immich AI Tagger: watch cycle complete"
+	}
+	if p.StoppedEarly {
+		title += " (stopped early)"
+	}
+	if p.DryRun {
+		title += " (dry run)"
+	}
+	return title
+}
+
+// discordMessage formats the summary as a Discord embed.
+func discordMessage(p webhookPayload) map[string]any {
+	color := 0x2ecc71 // green
+	if p.Failed > 0 {
+		color = 0xe67e22 // orange
+	}
+	var fields []map[string]any
+	for _, f := range p.summaryFields() {
+		fields = append(fields, map[string]any{"name": f[0], "value": f[1], "inline": true})
+	}
+	return map[string]any{
+		"embeds": []map[string]any{{
+			"title":     p.title(),
+			"color":     color,
+			"fields":    fields,
+			"timestamp": p.Timestamp.Format(time.RFC3339),
+		}},
+	}
+}
+
+// slackMessage formats the summary as Slack blocks, with a plain-text fallback.
+func slackMessage(p webhookPayload) map[string]any {
+	var fields []map[string]any
+	var text []string
+	for _, f := range p.summaryFields() {
+		fields = append(fields, map[string]any{"type": "mrkdwn", "text": fmt.Sprintf("*%s*\n%s", f[0], f[1])})
+		text = append(text, f[0]+": "+f[1])
+	}
+	return map[string]any{
+		"text": p.title() + " (" + strings.Join(text, ", ") + ")",
+		"blocks": []map[string]any{
+			{"type": "header", "text": map[string]any{"type": "plain_text", "text": p.title()}},
+			{"type": "section", "fields": fields},
+		},
+	}
+}
+
+func postJSON(ctx context.Context, url string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := (&http.Client{Transport: httpTransport}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}